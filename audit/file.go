@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// FileSink defines an audit sink that appends the records to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink creates a new FileSink that appends to the file in path, the file is
+// created if it does not exist
+func NewFileSink(path string) (*FileSink, error) {
+	const op = "Audit.NewFileSink"
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not open the audit file", err)
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the records to the file, one JSON object per line
+func (s *FileSink) Write(ctx context.Context, records []interfaces.AuditRecord) error {
+	const op = "Audit.FileSink.Write"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+
+	for _, record := range records {
+		err := enc.Encode(record)
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Could not encode the audit record", err)
+		}
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not write to the audit file", err)
+	}
+
+	return nil
+}
+
+// Close closes the audit file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -9,9 +11,17 @@ import (
 	"github.com/vanclief/state/interfaces"
 )
 
+// DefaultNamespace is the prefix of the keys written by a RedisStorage unless another
+// one is set
+const DefaultNamespace = "state"
+
+// purgeBatch is the number of keys requested on each SCAN while purging
+const purgeBatch = 100
+
 type RedisStorage struct {
-	Client *redis.Client
-	ttl    int
+	Client    *redis.Client
+	ttl       int
+	namespace string
 }
 
 // New instances a new redis client
@@ -36,24 +46,39 @@ func New(host, password string, db int) (*RedisStorage, error) {
 func newRedis(client *redis.Client) (*RedisStorage, error) {
 	// Return wrapper
 	return &RedisStorage{
-		Client: client,
-		ttl:    1,
+		Client:    client,
+		ttl:       1,
+		namespace: DefaultNamespace,
 	}, nil
 }
 
-func (s *RedisStorage) Get(m interfaces.Model, ID interface{}) error {
-	var id string
+// WithContext returns a RedisStorage that runs all of its commands with the context
+func (s *RedisStorage) WithContext(ctx context.Context) interfaces.Cache {
+	return &RedisStorage{Client: s.Client.WithContext(ctx), ttl: s.ttl, namespace: s.namespace}
+}
 
-	switch val := ID.(type) {
-	case string:
-		id = val
-	case []byte:
-		id = string(val)
-	default:
+// SetNamespace changes the prefix of the keys written by the RedisStorage, keys are
+// stored as namespace:schema:id. Keys written under the previous namespace are kept
+func (s *RedisStorage) SetNamespace(namespace string) error {
+	if namespace == "" {
+		return ez.New("redis.SetNamespace", ez.EINVALID, "The namespace can not be empty", nil)
+	}
+	s.namespace = namespace
+	return nil
+}
+
+// GetNamespace returns the prefix of the keys written by the RedisStorage
+func (s *RedisStorage) GetNamespace() string {
+	return s.namespace
+}
+
+func (s *RedisStorage) Get(m interfaces.Model, ID interface{}) error {
+	id, ok := cacheID(ID)
+	if !ok {
 		return ez.New("redis.Get", ez.EINVALID, "Can not use provided interface type", nil)
 	}
 
-	key := m.GetSchema().PKey + "-" + id
+	key := s.key(m, id)
 	value, err := s.Client.Get(key).Bytes()
 	if err == redis.Nil {
 		return ez.New("redis.Get", ez.ENOTFOUND, "not found", err)
@@ -68,8 +93,45 @@ func (s *RedisStorage) Get(m interfaces.Model, ID interface{}) error {
 	return nil
 }
 
+// cacheID returns the ID used in the key of a model, which can be a string or bytes
+func cacheID(ID interface{}) (string, bool) {
+	switch val := ID.(type) {
+	case string:
+		return val, true
+	case []byte:
+		return string(val), true
+	default:
+		return "", false
+	}
+}
+
+// Expiry returns when the model stored with the ID expires, the zero time if it never
+// does
+func (s *RedisStorage) Expiry(m interfaces.Model, ID interface{}) (time.Time, error) {
+	id, ok := cacheID(ID)
+	if !ok {
+		return time.Time{}, ez.New("redis.Expiry", ez.EINVALID, "Can not use provided interface type", nil)
+	}
+
+	ttl, err := s.Client.PTTL(s.key(m, id)).Result()
+	if err != nil {
+		return time.Time{}, ez.New("redis.Expiry", ez.EINTERNAL, "", err)
+	}
+
+	// Redis replies -2 if the key does not exist and -1 if it has no expiry, which the
+	// client scales to milliseconds
+	switch {
+	case ttl == -2*time.Millisecond:
+		return time.Time{}, ez.New("redis.Expiry", ez.ENOTFOUND, "not found", nil)
+	case ttl < 0:
+		return time.Time{}, nil
+	}
+
+	return time.Now().Add(ttl), nil
+}
+
 func (s *RedisStorage) Set(m interfaces.Model, ttl int) error {
-	key := m.GetSchema().PKey + "-" + m.GetID()
+	key := s.key(m, m.GetID())
 	encoded, err := json.Marshal(m)
 	if err != nil {
 		return err
@@ -82,7 +144,7 @@ func (s *RedisStorage) Set(m interfaces.Model, ttl int) error {
 }
 
 func (s *RedisStorage) Delete(m interfaces.Model) error {
-	key := m.GetSchema().PKey + "-" + m.GetID()
+	key := s.key(m, m.GetID())
 	err := s.Client.Del(key).Err()
 	if err != nil {
 		return ez.New("redis.Remove", ez.EINTERNAL, "", err)
@@ -90,14 +152,108 @@ func (s *RedisStorage) Delete(m interfaces.Model) error {
 	return nil
 }
 
+// SetMany adds the models to the cache with a single MULTI/EXEC pipeline
+func (s *RedisStorage) SetMany(models []interfaces.Model, ttl int) error {
+	encoded := make([][]byte, len(models))
+	for i, m := range models {
+		value, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		encoded[i] = value
+	}
+
+	_, err := s.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		for i, m := range models {
+			key := s.key(m, m.GetID())
+			pipe.Set(key, encoded[i], time.Duration(ttl)*time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		return ez.New("redis.SetMany", ez.EINTERNAL, "", err)
+	}
+	return nil
+}
+
+// DeleteMany removes the models from the cache with a single command
+func (s *RedisStorage) DeleteMany(models []interfaces.Model) error {
+	if len(models) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(models))
+	for i, m := range models {
+		keys[i] = s.key(m, m.GetID())
+	}
+
+	err := s.Client.Del(keys...).Err()
+	if err != nil {
+		return ez.New("redis.DeleteMany", ez.EINTERNAL, "", err)
+	}
+	return nil
+}
+
+// Purge removes all of the keys under the namespace of the RedisStorage, keys are
+// found with an incremental SCAN so the server is not blocked
 func (s *RedisStorage) Purge() error {
-	err := s.Client.Del("*").Err()
+	err := s.purge(escapePattern(s.namespace) + ":*")
 	if err != nil {
 		return ez.New("redis.Purge", ez.EINTERNAL, "", err)
 	}
 	return nil
 }
 
+// PurgeSchema removes all of the keys of a schema under the namespace of the RedisStorage
+func (s *RedisStorage) PurgeSchema(schema string) error {
+	err := s.purge(escapePattern(s.namespace) + ":" + escapePattern(schema) + ":*")
+	if err != nil {
+		return ez.New("redis.PurgeSchema", ez.EINTERNAL, "", err)
+	}
+	return nil
+}
+
+// purge deletes the keys that match the pattern one SCAN page at a time
+func (s *RedisStorage) purge(pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := s.Client.Scan(cursor, pattern, purgeBatch).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			err = s.Client.Del(keys...).Err()
+			if err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// key returns the key of a model, namespace:schema:id
+func (s *RedisStorage) key(m interfaces.Model, id string) string {
+	return s.namespace + ":" + m.GetSchema().Name + ":" + id
+}
+
+// escapePattern escapes the characters that have a special meaning in a SCAN pattern
+func escapePattern(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (s *RedisStorage) GetTTL() int {
 	return s.ttl
 }
@@ -0,0 +1,43 @@
+package shardedcache
+
+import "time"
+
+// DefaultShards is the number of shards used unless another one is set
+const DefaultShards = 32
+
+// Option modifies how the Cache is sharded and cleaned up
+type Option func(*options)
+
+type options struct {
+	shards          int
+	cleanupInterval time.Duration
+}
+
+// Shards sets the number of shards of the Cache, each shard has its own lock so more
+// shards reduce the contention between goroutines
+func Shards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// CleanupInterval starts a janitor that removes the expired models every interval.
+// Without it expired models are only removed when they are read
+func CleanupInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = interval
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{shards: DefaultShards}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.shards < 1 {
+		o.shards = 1
+	}
+
+	return o
+}
@@ -0,0 +1,262 @@
+package shardedcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// item defines a model stored in the cache encoded as JSON
+type item struct {
+	data    []byte
+	expires time.Time
+}
+
+// expired returns if the item should no longer be served
+func (i item) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// shard defines a portion of the keys of the cache guarded by its own lock
+type shard struct {
+	mu    sync.RWMutex
+	items map[string]item
+}
+
+// Cache defines an in-memory cache that splits its keys between shards by their FNV
+// hash, so goroutines that use different keys rarely wait for each other. It stores
+// copies of the models encoded as JSON and is safe for concurrent use
+type Cache struct {
+	shards []*shard
+	ttl    int
+	ttlMu  sync.RWMutex
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// New creates a new sharded cache, its models only expire if they are set with a TTL
+func New(opts ...Option) *Cache {
+	o := newOptions(opts)
+
+	c := &Cache{shards: make([]*shard, o.shards), stop: make(chan struct{})}
+	for i := range c.shards {
+		c.shards[i] = &shard{items: map[string]item{}}
+	}
+
+	if o.cleanupInterval > 0 {
+		go c.janitor(o.cleanupInterval)
+	}
+
+	return c
+}
+
+// Get obtains a model from the cache
+func (c *Cache) Get(m interfaces.Model, ID interface{}) error {
+	const op = "ShardedCache.Cache.Get"
+
+	id, ok := cacheID(ID)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Can not use provided interface type", nil)
+	}
+
+	key := cacheKey(m, id)
+	s := c.shard(key)
+
+	s.mu.RLock()
+	it, ok := s.items[key]
+	s.mu.RUnlock()
+
+	if ok && it.expired(time.Now()) {
+		s.mu.Lock()
+		// The item could have been replaced since it was read
+		current, exists := s.items[key]
+		if exists && current.expired(time.Now()) {
+			delete(s.items, key)
+		}
+		s.mu.Unlock()
+		ok = false
+	}
+
+	if !ok {
+		msg := fmt.Sprintf("Object with key: %s was not found in the cache", key)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	err := json.Unmarshal(it.data, m)
+	if err != nil {
+		return ez.New(op, ez.ECONFLICT, "Could not save retrieved object from cache", err)
+	}
+
+	return nil
+}
+
+// Expiry returns when the model stored with the ID expires, the zero time if it never
+// does
+func (c *Cache) Expiry(m interfaces.Model, ID interface{}) (time.Time, error) {
+	const op = "ShardedCache.Cache.Expiry"
+
+	id, ok := cacheID(ID)
+	if !ok {
+		return time.Time{}, ez.New(op, ez.EINVALID, "Can not use provided interface type", nil)
+	}
+
+	key := cacheKey(m, id)
+	s := c.shard(key)
+
+	s.mu.RLock()
+	it, ok := s.items[key]
+	s.mu.RUnlock()
+
+	if !ok || it.expired(time.Now()) {
+		msg := fmt.Sprintf("Object with key: %s was not found in the cache", key)
+		return time.Time{}, ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return it.expires, nil
+}
+
+// Set adds a copy of the model to the cache, it expires after ttl milliseconds or
+// never if ttl is 0
+func (c *Cache) Set(m interfaces.Model, ttl int) error {
+	const op = "ShardedCache.Cache.Set"
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not encode the object", err)
+	}
+
+	it := item{data: data}
+	if ttl > 0 {
+		it.expires = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	key := cacheKey(m, m.GetID())
+	s := c.shard(key)
+
+	s.mu.Lock()
+	s.items[key] = it
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Delete removes a model from the cache
+func (c *Cache) Delete(m interfaces.Model) error {
+	key := cacheKey(m, m.GetID())
+	s := c.shard(key)
+
+	s.mu.Lock()
+	delete(s.items, key)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// GetTTL returns the TTL in milliseconds used by the Manager to set models, 0 means
+// they never expire
+func (c *Cache) GetTTL() int {
+	c.ttlMu.RLock()
+	defer c.ttlMu.RUnlock()
+
+	return c.ttl
+}
+
+// SetTTL sets the TTL in milliseconds used by the Manager to set models
+func (c *Cache) SetTTL(ms int) error {
+	const op = "ShardedCache.Cache.SetTTL"
+
+	if ms < 0 {
+		return ez.New(op, ez.EINVALID, "The TTL can not be negative", nil)
+	}
+
+	c.ttlMu.Lock()
+	defer c.ttlMu.Unlock()
+
+	c.ttl = ms
+	return nil
+}
+
+// Purge clears the cache, one shard at a time
+func (c *Cache) Purge() error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = map[string]item{}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Len returns the number of models stored in the cache, including the expired ones
+// that have not been removed yet
+func (c *Cache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		n += len(s.items)
+		s.mu.RUnlock()
+	}
+
+	return n
+}
+
+// Close stops the janitor of the cache if it was started
+func (c *Cache) Close() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+// shard returns the shard that holds the key
+func (c *Cache) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// janitor removes the expired items every interval until the cache is closed, it
+// locks a single shard at a time
+func (c *Cache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for _, s := range c.shards {
+				s.mu.Lock()
+				for key, it := range s.items {
+					if it.expired(now) {
+						delete(s.items, key)
+					}
+				}
+				s.mu.Unlock()
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// cacheID returns the ID used as key of a model, which can be a string or bytes
+func cacheID(ID interface{}) (string, bool) {
+	switch val := ID.(type) {
+	case string:
+		return val, true
+	case []byte:
+		return string(val), true
+	default:
+		return "", false
+	}
+}
+
+// cacheKey returns the key of a model, schema:id
+func cacheKey(m interfaces.Model, id string) string {
+	return m.GetSchema().Name + ":" + id
+}
@@ -0,0 +1,142 @@
+package simplecache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// Policy defines how the Cache chooses which model to evict when it is full
+type Policy int
+
+// Eviction policies
+const (
+	// LRU evicts the least recently used model
+	LRU Policy = iota
+	// LFU evicts the least frequently used model, ties are broken by recency. Counts are
+	// aged, a new model starts with the count of the last evicted one, so new models are
+	// not always evicted first and models that were popular long ago are evicted
+	// eventually
+	LFU
+)
+
+// evictor keeps the order in which the entries of the Cache should be evicted
+type evictor interface {
+	// add tracks a new entry
+	add(e *entry)
+	// touch records that an entry was used
+	touch(e *entry)
+	// remove stops tracking an entry
+	remove(e *entry)
+	// evict stops tracking an entry that is evicted
+	evict(e *entry)
+	// victim returns the entry that should be evicted next, nil if there are none
+	victim() *entry
+}
+
+func newEvictor(policy Policy) evictor {
+	if policy == LFU {
+		return &lfu{}
+	}
+
+	return &lru{order: list.New()}
+}
+
+// lru orders the entries from the most to the least recently used
+type lru struct {
+	order *list.List
+}
+
+func (p *lru) add(e *entry) {
+	e.elem = p.order.PushFront(e)
+}
+
+func (p *lru) touch(e *entry) {
+	p.order.MoveToFront(e.elem)
+}
+
+func (p *lru) remove(e *entry) {
+	p.order.Remove(e.elem)
+}
+
+func (p *lru) evict(e *entry) {
+	p.remove(e)
+}
+
+func (p *lru) victim() *entry {
+	back := p.order.Back()
+	if back == nil {
+		return nil
+	}
+
+	return back.Value.(*entry)
+}
+
+// lfu keeps the entries in a min-heap ordered by their use count and last use. The age
+// is the count of the last evicted entry
+type lfu struct {
+	entries []*entry
+	clock   uint64
+	age     uint64
+}
+
+func (p *lfu) add(e *entry) {
+	p.clock++
+	e.hits = p.age + 1
+	e.used = p.clock
+	heap.Push(p, e)
+}
+
+func (p *lfu) touch(e *entry) {
+	p.clock++
+	e.hits++
+	e.used = p.clock
+	heap.Fix(p, e.index)
+}
+
+func (p *lfu) remove(e *entry) {
+	heap.Remove(p, e.index)
+}
+
+func (p *lfu) evict(e *entry) {
+	p.age = e.hits
+	p.remove(e)
+}
+
+func (p *lfu) victim() *entry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+
+	return p.entries[0]
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface
+
+func (p *lfu) Len() int { return len(p.entries) }
+
+func (p *lfu) Less(i, j int) bool {
+	if p.entries[i].hits != p.entries[j].hits {
+		return p.entries[i].hits < p.entries[j].hits
+	}
+	return p.entries[i].used < p.entries[j].used
+}
+
+func (p *lfu) Swap(i, j int) {
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+	p.entries[i].index = i
+	p.entries[j].index = j
+}
+
+func (p *lfu) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *lfu) Pop() interface{} {
+	n := len(p.entries)
+	e := p.entries[n-1]
+	p.entries[n-1] = nil
+	p.entries = p.entries[:n-1]
+	return e
+}
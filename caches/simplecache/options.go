@@ -0,0 +1,53 @@
+package simplecache
+
+import "time"
+
+// Option modifies how the Cache stores and evicts models
+type Option func(*options)
+
+type options struct {
+	maxEntries      int
+	maxBytes        int
+	policy          Policy
+	cleanupInterval time.Duration
+}
+
+// MaxEntries limits the number of models stored in the Cache, once it is reached the
+// eviction policy decides which model is removed to make room for a new one
+func MaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// MaxBytes limits the size of the encoded models stored in the Cache, once it is
+// reached the eviction policy decides which models are removed to make room for a new one
+func MaxBytes(n int) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// Eviction sets the policy used to choose which models are removed when the Cache is
+// full, LRU is used by default
+func Eviction(policy Policy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// CleanupInterval starts a janitor that removes the expired models every interval.
+// Without it expired models are only removed when they are read or evicted
+func CleanupInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = interval
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{policy: LRU}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
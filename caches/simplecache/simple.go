@@ -1,45 +1,101 @@
 package simplecache
 
 import (
+	"container/list"
+	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/vanclief/ez"
 	"github.com/vanclief/state/interfaces"
 )
 
-// Cache defines a simple map cache
+// entry defines a model stored in the cache encoded as JSON
+type entry struct {
+	key     string
+	data    []byte
+	expires time.Time
+
+	// Used by the eviction policies
+	elem  *list.Element
+	index int
+	hits  uint64
+	used  uint64
+}
+
+// size returns the number of bytes the entry counts against the byte budget
+func (e *entry) size() int {
+	return len(e.key) + len(e.data)
+}
+
+// expired returns if the entry should no longer be served
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// Cache defines an in-memory cache that stores copies of the models encoded as JSON.
+// Entries expire after their TTL, and the cache can be bounded by number of entries or
+// bytes. It is safe for concurrent use
 type Cache struct {
-	memory map[string]interfaces.Model
+	mu      sync.Mutex
+	entries map[string]*entry
+	evictor evictor
+	bytes   int
+	ttl     int
+	opts    *options
+	stop    chan struct{}
+	once    sync.Once
 }
 
-// New creates a new SimpleCache
-func New() *Cache {
-	return &Cache{map[string]interfaces.Model{}}
+// New creates a new SimpleCache, without options it is unbounded and its models never
+// expire unless they are set with a TTL
+func New(opts ...Option) *Cache {
+	o := newOptions(opts)
+
+	c := &Cache{
+		entries: map[string]*entry{},
+		evictor: newEvictor(o.policy),
+		opts:    o,
+		stop:    make(chan struct{}),
+	}
+
+	if o.cleanupInterval > 0 {
+		go c.janitor(o.cleanupInterval)
+	}
+
+	return c
 }
 
 // Get obtains a model from the cache
 func (c *Cache) Get(m interfaces.Model, ID interface{}) error {
 	const op = "Simplecache.Cache.Get"
 
-	var id string
-
-	switch val := ID.(type) {
-	case string:
-		id = val
-	case []byte:
-		id = string(val)
-	default:
+	id, ok := cacheID(ID)
+	if !ok {
 		return ez.New(op, ez.EINVALID, "Can not use provided interface type", nil)
 	}
 
-	key := m.GetSchema().PKey + ":" + id
-	val, ok := c.memory[key]
+	key := cacheKey(m, id)
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && e.expired(time.Now()) {
+		c.remove(e)
+		ok = false
+	}
+	if ok {
+		c.evictor.touch(e)
+	}
+	c.mu.Unlock()
+
 	if !ok {
 		msg := fmt.Sprintf("Object with key: %s was not found in the cache", key)
 		return ez.New(op, ez.ENOTFOUND, msg, nil)
 	}
 
-	err := m.Update(val)
+	// The stored data is never modified, so it can be decoded outside of the lock
+	err := json.Unmarshal(e.data, m)
 	if err != nil {
 		return ez.New(op, ez.ECONFLICT, "Could not save retrieved object from cache", err)
 	}
@@ -47,34 +103,208 @@ func (c *Cache) Get(m interfaces.Model, ID interface{}) error {
 	return nil
 }
 
-// Set adds a model to the cache
+// Expiry returns when the model stored with the ID expires, the zero time if it never
+// does
+func (c *Cache) Expiry(m interfaces.Model, ID interface{}) (time.Time, error) {
+	const op = "Simplecache.Cache.Expiry"
+
+	id, ok := cacheID(ID)
+	if !ok {
+		return time.Time{}, ez.New(op, ez.EINVALID, "Can not use provided interface type", nil)
+	}
+
+	key := cacheKey(m, id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.expired(time.Now()) {
+		msg := fmt.Sprintf("Object with key: %s was not found in the cache", key)
+		return time.Time{}, ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return e.expires, nil
+}
+
+// Set adds a copy of the model to the cache, it expires after ttl milliseconds or
+// never if ttl is 0. Models larger than the byte budget are not stored
 func (c *Cache) Set(m interfaces.Model, ttl int) error {
-	key := m.GetSchema().PKey + ":" + m.GetID()
-	c.memory[key] = m
+	const op = "Simplecache.Cache.Set"
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not encode the object", err)
+	}
+
+	e := &entry{key: cacheKey(m, m.GetID()), data: data}
+	if ttl > 0 {
+		e.expires = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev, ok := c.entries[e.key]
+	if ok {
+		c.remove(prev)
+	}
+
+	if c.opts.maxBytes > 0 && e.size() > c.opts.maxBytes {
+		return nil
+	}
+
+	c.makeRoom(e)
+
+	c.entries[e.key] = e
+	c.evictor.add(e)
+	c.bytes += e.size()
 	return nil
 }
 
 // Delete removes a model from the cache
 func (c *Cache) Delete(m interfaces.Model) error {
-	key := m.GetSchema().PKey + ":" + m.GetID()
-	delete(c.memory, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[cacheKey(m, m.GetID())]
+	if ok {
+		c.remove(e)
+	}
+
 	return nil
 }
 
-// GetTTL returns the Cache TTL
+// GetTTL returns the TTL in milliseconds used by the Manager to set models, 0 means
+// they never expire
 func (c *Cache) GetTTL() int {
-	// Does not apply for simple cache
-	return 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ttl
 }
 
-// SetTTL sets the Cache TTL
+// SetTTL sets the TTL in milliseconds used by the Manager to set models
 func (c *Cache) SetTTL(ms int) error {
-	// Does not apply for simple cache
+	const op = "Simplecache.Cache.SetTTL"
+
+	if ms < 0 {
+		return ez.New(op, ez.EINVALID, "The TTL can not be negative", nil)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ms
 	return nil
 }
 
 // Purge clears the cache
 func (c *Cache) Purge() error {
-	*c = Cache{map[string]interfaces.Model{}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*entry{}
+	c.evictor = newEvictor(c.opts.policy)
+	c.bytes = 0
 	return nil
 }
+
+// Len returns the number of models stored in the cache, including the expired ones
+// that have not been removed yet
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Close stops the janitor of the cache if it was started
+func (c *Cache) Close() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+// makeRoom evicts entries until the new entry fits within the bounds of the cache. The
+// expired entries are removed before any live entry is evicted. It runs before the
+// entry is added, so a new entry is never chosen as the victim. The caller must hold
+// the lock
+func (c *Cache) makeRoom(e *entry) {
+	if !c.overflows(e) {
+		return
+	}
+
+	c.removeExpired(time.Now())
+
+	for c.overflows(e) {
+		victim := c.evictor.victim()
+		if victim == nil {
+			return
+		}
+
+		delete(c.entries, victim.key)
+		c.evictor.evict(victim)
+		c.bytes -= victim.size()
+	}
+}
+
+// overflows returns if adding the entry would exceed any of the bounds of the cache,
+// the caller must hold the lock
+func (c *Cache) overflows(e *entry) bool {
+	if c.opts.maxEntries > 0 && len(c.entries) >= c.opts.maxEntries {
+		return true
+	}
+
+	return c.opts.maxBytes > 0 && c.bytes+e.size() > c.opts.maxBytes
+}
+
+// remove deletes an entry from the cache, the caller must hold the lock
+func (c *Cache) remove(e *entry) {
+	delete(c.entries, e.key)
+	c.evictor.remove(e)
+	c.bytes -= e.size()
+}
+
+// removeExpired deletes all of the expired entries, the caller must hold the lock
+func (c *Cache) removeExpired(now time.Time) {
+	for _, e := range c.entries {
+		if e.expired(now) {
+			c.remove(e)
+		}
+	}
+}
+
+// janitor removes the expired entries every interval until the cache is closed
+func (c *Cache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.removeExpired(time.Now())
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// cacheID returns the ID used as key of a model, which can be a string or bytes
+func cacheID(ID interface{}) (string, bool) {
+	switch val := ID.(type) {
+	case string:
+		return val, true
+	case []byte:
+		return string(val), true
+	default:
+		return "", false
+	}
+}
+
+// cacheKey returns the key of a model, schema:id
+func cacheKey(m interfaces.Model, id string) string {
+	return m.GetSchema().Name + ":" + id
+}
@@ -0,0 +1,270 @@
+package tiered
+
+import (
+	"context"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// Cache defines a two level cache. Models are read from the L1 cache, usually in
+// process, and then from the L2 cache, usually shared like Redis, promoting the models
+// found in L2 to L1. Writes and deletes are applied to both levels
+type Cache struct {
+	L1 interfaces.Cache
+	L2 interfaces.Cache
+}
+
+// New creates a new two level cache
+func New(l1, l2 interfaces.Cache) (*Cache, error) {
+	const op = "Tiered.New"
+
+	if l1 == nil || l2 == nil {
+		return nil, ez.New(op, ez.EINVALID, "A tiered cache requires both an L1 and an L2 cache", nil)
+	}
+
+	return &Cache{L1: l1, L2: l2}, nil
+}
+
+// WithContext returns a Cache whose L2 runs its operations with the context, if it
+// supports it. The L1 is expected to be in process so it is not bound
+func (c *Cache) WithContext(ctx context.Context) interfaces.Cache {
+	l2, ok := c.L2.(interfaces.ContextCache)
+	if !ok {
+		return c
+	}
+
+	return &Cache{L1: c.L1, L2: l2.WithContext(ctx)}
+}
+
+// Get obtains a model from L1, or from L2 and stores it in L1
+func (c *Cache) Get(m interfaces.Model, ID interface{}) error {
+	const op = "Tiered.Cache.Get"
+
+	err := c.L1.Get(m, ID)
+	if err == nil {
+		return nil
+	}
+
+	err = c.L2.Get(m, ID)
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	// Failing to promote the model should not fail the read
+	ttl, ok := c.promotionTTL(m, ID)
+	if ok {
+		c.L1.Set(m, c.l1TTL(ttl))
+	}
+
+	return nil
+}
+
+// promotionTTL returns the TTL used to promote a model read from L2. If L2 reports the
+// expiry of the model it is the time the model has left there, so L1 does not serve it
+// after L2 expired it, otherwise it is the TTL of L2. Returns false if the model
+// already expired from L2
+func (c *Cache) promotionTTL(m interfaces.Model, ID interface{}) (int, bool) {
+	l2, ok := c.L2.(interfaces.ExpiryCache)
+	if !ok {
+		return c.L2.GetTTL(), true
+	}
+
+	expires, err := l2.Expiry(m, ID)
+	if err != nil {
+		return c.L2.GetTTL(), true
+	}
+
+	if expires.IsZero() {
+		return 0, true
+	}
+
+	remaining := time.Until(expires).Milliseconds()
+	if remaining <= 0 {
+		return 0, false
+	}
+
+	return int(remaining), true
+}
+
+// Expiry returns when the model expires from L1, or from L2 if it is not in L1. Levels
+// that do not implement interfaces.ExpiryCache are skipped
+func (c *Cache) Expiry(m interfaces.Model, ID interface{}) (time.Time, error) {
+	const op = "Tiered.Cache.Expiry"
+
+	l1, ok := c.L1.(interfaces.ExpiryCache)
+	if ok {
+		expires, err := l1.Expiry(m, ID)
+		if err == nil {
+			return expires, nil
+		}
+	}
+
+	l2, ok := c.L2.(interfaces.ExpiryCache)
+	if !ok {
+		return time.Time{}, ez.New(op, ez.ENOTFOUND, "The model was not found in a cache that reports its expiry", nil)
+	}
+
+	expires, err := l2.Expiry(m, ID)
+	if err != nil {
+		return time.Time{}, ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return expires, nil
+}
+
+// Set adds the model to L2 and then to L1. If L2 fails the model is removed from L1,
+// so it does not serve a copy that L2 does not have
+func (c *Cache) Set(m interfaces.Model, ttl int) error {
+	const op = "Tiered.Cache.Set"
+
+	err := c.L2.Set(m, ttl)
+	if err != nil {
+		c.L1.Delete(m)
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	err = c.L1.Set(m, c.l1TTL(ttl))
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return nil
+}
+
+// Delete removes the model from both levels, L1 is cleared even if L2 fails
+func (c *Cache) Delete(m interfaces.Model) error {
+	const op = "Tiered.Cache.Delete"
+
+	l1Err := c.L1.Delete(m)
+
+	err := c.L2.Delete(m)
+	if err == nil {
+		err = l1Err
+	}
+
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return nil
+}
+
+// SetMany adds the models to both levels, using a single round trip if L2 supports it
+func (c *Cache) SetMany(models []interfaces.Model, ttl int) error {
+	const op = "Tiered.Cache.SetMany"
+
+	l2, ok := c.L2.(interfaces.BatchCache)
+	if !ok {
+		for _, m := range models {
+			err := c.Set(m, ttl)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := l2.SetMany(models, ttl)
+	if err != nil {
+		for _, m := range models {
+			c.L1.Delete(m)
+		}
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	for _, m := range models {
+		err = c.L1.Set(m, c.l1TTL(ttl))
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteMany removes the models from both levels, using a single round trip if L2
+// supports it
+func (c *Cache) DeleteMany(models []interfaces.Model) error {
+	const op = "Tiered.Cache.DeleteMany"
+
+	l2, ok := c.L2.(interfaces.BatchCache)
+	if !ok {
+		var err error
+		for _, m := range models {
+			deleteErr := c.Delete(m)
+			if deleteErr != nil {
+				err = deleteErr
+			}
+		}
+		return err
+	}
+
+	var l1Err error
+	for _, m := range models {
+		deleteErr := c.L1.Delete(m)
+		if deleteErr != nil {
+			l1Err = deleteErr
+		}
+	}
+
+	err := l2.DeleteMany(models)
+	if err == nil {
+		err = l1Err
+	}
+
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return nil
+}
+
+// Local returns L1, which is expected to be in process
+func (c *Cache) Local() interfaces.Cache {
+	return c.L1
+}
+
+// GetTTL returns the TTL of L2, which is the one used by the Manager to set models
+func (c *Cache) GetTTL() int {
+	return c.L2.GetTTL()
+}
+
+// SetTTL sets the TTL of L2
+func (c *Cache) SetTTL(ttl int) error {
+	return c.L2.SetTTL(ttl)
+}
+
+// Purge clears both levels
+func (c *Cache) Purge() error {
+	const op = "Tiered.Cache.Purge"
+
+	l1Err := c.L1.Purge()
+
+	err := c.L2.Purge()
+	if err == nil {
+		err = l1Err
+	}
+
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return nil
+}
+
+// l1TTL returns the TTL used to store a model in L1, it never outlives the TTL of L1
+// or the requested one. A TTL of 0 means the model never expires
+func (c *Cache) l1TTL(ttl int) int {
+	l1 := c.L1.GetTTL()
+
+	switch {
+	case l1 == 0:
+		return ttl
+	case ttl == 0 || l1 < ttl:
+		return l1
+	default:
+		return ttl
+	}
+}
@@ -0,0 +1,150 @@
+package memdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// InsertMany adds the models into the database, if any of them can not be inserted
+// none of them is
+func (db *DB) InsertMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.InsertMany"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	encoded := make([][]byte, len(models))
+	ids := map[string]bool{}
+
+	for i, m := range models {
+		_, ok, err := db.lookup(m, m.GetID())
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+		}
+
+		if ok || ids[m.GetSchema().Name+":"+m.GetID()] {
+			errMsg := fmt.Sprintf("Error inserting %s into %s, it already exists", m.GetID(), m.GetSchema().Name)
+			return ez.New(op, ez.ECONFLICT, errMsg, nil)
+		}
+		ids[m.GetSchema().Name+":"+m.GetID()] = true
+
+		encoded[i], err = json.Marshal(m)
+		if err != nil {
+			errMsg := fmt.Sprintf("Error inserting %s into %s", m.GetID(), m.GetSchema().Name)
+			return ez.New(op, ez.EINTERNAL, errMsg, err)
+		}
+	}
+
+	for i, m := range models {
+		db.put(m, record{seq: db.nextSeq(), data: encoded[i]})
+	}
+
+	return nil
+}
+
+// UpdateMany changes existing models from the database, if any of them can not be
+// updated none of them is
+func (db *DB) UpdateMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.UpdateMany"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	records := make([]record, len(models))
+
+	for i, m := range models {
+		r, ok, err := db.lookup(m, m.GetID())
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+		}
+
+		if !ok {
+			errMsg := fmt.Sprintf("Error updating %s from %s, it does not exist", m.GetID(), m.GetSchema().Name)
+			return ez.New(op, ez.ENOTFOUND, errMsg, nil)
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			errMsg := fmt.Sprintf("Error updating %s from %s", m.GetID(), m.GetSchema().Name)
+			return ez.New(op, ez.EINTERNAL, errMsg, err)
+		}
+
+		records[i] = record{seq: r.seq, data: data}
+	}
+
+	for i, m := range models {
+		db.put(m, records[i])
+	}
+
+	return nil
+}
+
+// DeleteMany removes existing models from the database, if any of them can not be
+// deleted none of them is
+func (db *DB) DeleteMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.DeleteMany"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ids := map[string]bool{}
+
+	for _, m := range models {
+		_, ok, err := db.lookup(m, m.GetID())
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+		}
+
+		// A model deleted earlier in the batch no longer exists
+		if !ok || ids[m.GetSchema().Name+":"+m.GetID()] {
+			errMsg := fmt.Sprintf("Error deleting %s from %s, it does not exist", m.GetID(), m.GetSchema().Name)
+			return ez.New(op, ez.ENOTFOUND, errMsg, nil)
+		}
+		ids[m.GetSchema().Name+":"+m.GetID()] = true
+	}
+
+	for _, m := range models {
+		db.remove(m)
+	}
+
+	return nil
+}
+
+// InsertMany adds the models into the database
+func (db *ctxDB) InsertMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.InsertMany"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.InsertMany(models)
+}
+
+// UpdateMany changes existing models from the database
+func (db *ctxDB) UpdateMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.UpdateMany"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.UpdateMany(models)
+}
+
+// DeleteMany removes existing models from the database
+func (db *ctxDB) DeleteMany(models []interfaces.Model) error {
+	const op = "MemDB.DB.DeleteMany"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.DeleteMany(models)
+}
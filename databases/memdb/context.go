@@ -0,0 +1,174 @@
+package memdb
+
+import (
+	"context"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
+)
+
+// ctxDB defines an in-memory database bound to a context, operations fail once the
+// context is canceled
+type ctxDB struct {
+	*DB
+	ctx context.Context
+}
+
+// WithContext returns a database that fails all of its operations once the context
+// is canceled. A transaction started with a context is rolled back if the context is
+// canceled before it is commited
+func (db *DB) WithContext(ctx context.Context) interfaces.Database {
+	return &ctxDB{DB: db, ctx: ctx}
+}
+
+// Begin starts a new transaction bound to the context
+func (db *ctxDB) Begin() (interfaces.TxDatabase, error) {
+	const op = "MemDB.DB.Begin"
+
+	err := db.err(op)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ctxDB{DB: tx.(*DB), ctx: db.ctx}, nil
+}
+
+// Commit replaces the original database with the changes applied during the
+// transaction, the changes are discarded if the context was canceled
+func (db *ctxDB) Commit() error {
+	const op = "MemDB.DB.Commit"
+
+	err := db.err(op)
+	if err != nil {
+		if db.parent != nil {
+			db.DB.Rollback()
+		}
+		return err
+	}
+
+	return db.DB.Commit()
+}
+
+// Get returns a single model from the database using its ID
+func (db *ctxDB) Get(m interfaces.Model, ID interface{}) error {
+	const op = "MemDB.DB.Get"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Get(m, ID)
+}
+
+// QueryOne returns a single model from the database that satisfies a Query
+func (db *ctxDB) QueryOne(m interfaces.Model, q *query.Query) error {
+	const op = "MemDB.DB.QueryOne"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.QueryOne(m, q)
+}
+
+// Query returns a list of models from the database that satisfy a Query
+func (db *ctxDB) Query(mList interface{}, model interfaces.Model, q *query.Query) error {
+	const op = "MemDB.DB.Query"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Query(mList, model, q)
+}
+
+// Insert adds a model into the database
+func (db *ctxDB) Insert(m interfaces.Model) error {
+	const op = "MemDB.DB.Insert"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Insert(m)
+}
+
+// Update changes an existing model from the database
+func (db *ctxDB) Update(m interfaces.Model) error {
+	const op = "MemDB.DB.Update"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Update(m)
+}
+
+// Delete removes an existing model from the database
+func (db *ctxDB) Delete(m interfaces.Model) error {
+	const op = "MemDB.DB.Delete"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Delete(m)
+}
+
+// CreateSchema creates a table for each model
+func (db *ctxDB) CreateSchema(modelsList []interface{}, dropExisting bool) error {
+	const op = "MemDB.DB.CreateSchema"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.CreateSchema(modelsList, dropExisting)
+}
+
+// ReadOutbox returns up to limit entries that have not been delivered
+func (db *ctxDB) ReadOutbox(limit int) ([]interfaces.OutboxEntry, error) {
+	const op = "MemDB.DB.ReadOutbox"
+
+	err := db.err(op)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.DB.ReadOutbox(limit)
+}
+
+// MarkDelivered marks the entries with the provided IDs as delivered
+func (db *ctxDB) MarkDelivered(ids []int64) error {
+	const op = "MemDB.DB.MarkDelivered"
+
+	err := db.err(op)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.MarkDelivered(ids)
+}
+
+// err returns an error if the context is canceled
+func (db *ctxDB) err(op string) error {
+	err := db.ctx.Err()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "The context of the operation is done", err)
+	}
+
+	return nil
+}
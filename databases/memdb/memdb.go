@@ -0,0 +1,461 @@
+package memdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
+)
+
+// record defines a model stored in a table, seq keeps the insertion order
+type record struct {
+	seq  int64
+	data []byte
+}
+
+// table defines the stored models of a Schema using their ID as key
+type table map[string]record
+
+// DB defines an in-memory database that stores models encoded as JSON in Go maps
+type DB struct {
+	mu     *sync.RWMutex
+	txMu   *sync.Mutex
+	tables map[string]table
+	seq    int64
+	outbox []outboxRecord
+	parent *DB
+
+	// Used by transactions to keep their writes until they are commited
+	writes    overlay
+	reset     map[string]bool
+	delivered []int64
+}
+
+// New returns a new empty in-memory database
+func New() *DB {
+	return &DB{mu: &sync.RWMutex{}, txMu: &sync.Mutex{}, tables: map[string]table{}}
+}
+
+// Begin starts a new transaction. The transaction keeps its writes apart and reads
+// through them to the original database, on Commit only those writes are applied to
+// it. Transactions are serialized, so Begin waits until the previous transaction is
+// commited or rolled back, while the original database can still be used directly.
+// When both write the same model the last one to be applied wins
+func (db *DB) Begin() (interfaces.TxDatabase, error) {
+	const op = "MemDB.DB.Begin"
+
+	if db.parent != nil {
+		return nil, ez.New(op, ez.ECONFLICT, "A transaction is already in progress", nil)
+	}
+
+	db.txMu.Lock()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return &DB{mu: &sync.RWMutex{}, seq: db.seq, parent: db}, nil
+}
+
+// Commit applies the writes of the transaction to the original database
+func (db *DB) Commit() error {
+	const op = "MemDB.DB.Commit"
+
+	if db.parent == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	parent := db.parent
+
+	parent.mu.Lock()
+	db.apply(parent)
+	parent.mu.Unlock()
+
+	parent.txMu.Unlock()
+	db.clear()
+	return nil
+}
+
+// Rollback discards the changes applied during the transaction
+func (db *DB) Rollback() error {
+	const op = "MemDB.DB.Rollback"
+
+	if db.parent == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.parent.txMu.Unlock()
+	db.clear()
+	return nil
+}
+
+// apply writes the changes of the transaction into the original database, the caller
+// must hold the lock of both
+func (db *DB) apply(parent *DB) {
+	for name := range db.reset {
+		parent.tables[name] = table{}
+	}
+
+	type write struct {
+		name string
+		id   string
+		r    *record
+	}
+
+	writes := []write{}
+	for name, t := range db.writes {
+		for id, r := range t {
+			writes = append(writes, write{name, id, r})
+		}
+	}
+
+	// New models are inserted in the same order as in the transaction
+	sort.Slice(writes, func(i, j int) bool {
+		if writes[i].r == nil || writes[j].r == nil {
+			return writes[j].r != nil
+		}
+		return writes[i].r.seq < writes[j].r.seq
+	})
+
+	for _, w := range writes {
+		t, ok := parent.tables[w.name]
+		if !ok {
+			t = table{}
+			parent.tables[w.name] = t
+		}
+
+		if w.r == nil {
+			delete(t, w.id)
+			continue
+		}
+
+		r := *w.r
+		existing, ok := t[w.id]
+		if ok {
+			r.seq = existing.seq
+		} else {
+			r.seq = parent.nextSeq()
+		}
+		t[w.id] = r
+	}
+
+	for _, r := range db.outbox {
+		r.entry.ID = int64(len(parent.outbox) + 1)
+		parent.outbox = append(parent.outbox, r)
+	}
+
+	parent.markDelivered(db.delivered)
+}
+
+// clear ends the transaction, the caller must hold the lock
+func (db *DB) clear() {
+	db.parent = nil
+	db.tables = map[string]table{}
+	db.outbox = nil
+	db.writes = nil
+	db.reset = nil
+	db.delivered = nil
+}
+
+// Get returns a single model from the database using its ID
+func (db *DB) Get(m interfaces.Model, ID interface{}) error {
+	const op = "MemDB.DB.Get"
+
+	var id string
+
+	switch val := ID.(type) {
+	case string:
+		id = val
+	case []byte:
+		id = string(val)
+	default:
+		return ez.New(op, ez.EINVALID, "Can not use provided ID interface type", nil)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	r, ok, err := db.lookup(m, id)
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if !ok {
+		msg := fmt.Sprintf("Could not find a %s model with id %s", m.GetSchema().Name, id)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	err = decode(r.data, m)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not decode stored model", err)
+	}
+
+	return nil
+}
+
+// QueryOne returns a single model from the database that satisfies a Query.
+// The method will return an error if there is more than one result from the query
+func (db *DB) QueryOne(m interfaces.Model, q *query.Query) error {
+	const op = "MemDB.DB.QueryOne"
+
+	stmt, err := newStatement(q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	results, err := db.find(m, stmt)
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	switch len(results) {
+	case 0:
+		msg := fmt.Sprintf("Could not find a %s model that satisfies the query", m.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	case 1:
+	default:
+		msg := fmt.Sprintf("Could find multiple %s models that satisfy QueryOne", m.GetSchema().Name)
+		return ez.New(op, ez.ECONFLICT, msg, nil)
+	}
+
+	err = decode(results[0], m)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not decode stored model", err)
+	}
+
+	return nil
+}
+
+// Query returns a list of models from the database that satisfy a Query
+func (db *DB) Query(mList interface{}, model interfaces.Model, q *query.Query) error {
+	const op = "MemDB.DB.Query"
+
+	stmt, err := newStatement(q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	results, err := db.find(model, stmt)
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if len(results) == 0 {
+		msg := fmt.Sprintf("Could not find any %s that satisfies the query", model.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	encoded := append([]byte("["), bytes.Join(results, []byte(","))...)
+	encoded = append(encoded, ']')
+
+	err = json.Unmarshal(encoded, mList)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not decode stored models", err)
+	}
+
+	return nil
+}
+
+// RawQuery is not supported by the in-memory database
+func (db *DB) RawQuery(mList interface{}, model interfaces.Model, rawQuery string, args ...interface{}) error {
+	const op = "MemDB.DB.RawQuery"
+	return ez.New(op, ez.EINVALID, "Raw queries are not supported by the in-memory database", nil)
+}
+
+// Insert adds a model into the database
+func (db *DB) Insert(m interfaces.Model) error {
+	const op = "MemDB.DB.Insert"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, ok, err := db.lookup(m, m.GetID())
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if ok {
+		errMsg := fmt.Sprintf("Error inserting %s into %s, it already exists", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.ECONFLICT, errMsg, nil)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error inserting %s into %s", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	db.put(m, record{seq: db.nextSeq(), data: data})
+	return nil
+}
+
+// Update changes an existing model from the database
+func (db *DB) Update(m interfaces.Model) error {
+	const op = "MemDB.DB.Update"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok, err := db.lookup(m, m.GetID())
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if !ok {
+		errMsg := fmt.Sprintf("Error updating %s from %s, it does not exist", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, errMsg, nil)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error updating %s from %s", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	db.put(m, record{seq: r.seq, data: data})
+	return nil
+}
+
+// Delete removes an existing model from the database
+func (db *DB) Delete(m interfaces.Model) error {
+	const op = "MemDB.DB.Delete"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, ok, err := db.lookup(m, m.GetID())
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if !ok {
+		errMsg := fmt.Sprintf("Error deleting %s from %s, it does not exist", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, errMsg, nil)
+	}
+
+	db.remove(m)
+	return nil
+}
+
+// CreateSchema creates a table for each model, if dropExisting is set to true it will
+// drop the models currently stored
+func (db *DB) CreateSchema(modelsList []interface{}, dropExisting bool) error {
+	const op = "MemDB.DB.CreateSchema"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, model := range modelsList {
+		m, ok := model.(interfaces.Model)
+		if !ok {
+			return ez.New(op, ez.EINVALID, "Provided interface is not a Model", nil)
+		}
+
+		name := m.GetSchema().Name
+		if db.hasTable(name) && !dropExisting {
+			continue
+		}
+
+		if db.parent == nil {
+			db.tables[name] = table{}
+			continue
+		}
+
+		// Inside a transaction the table replaces the original one when it is commited
+		if db.reset == nil {
+			db.reset = map[string]bool{}
+		}
+		db.reset[name] = true
+		delete(db.writes, name)
+	}
+
+	return nil
+}
+
+// find returns the encoded models from the model table that satisfy the statement
+func (db *DB) find(m interfaces.Model, stmt *statement) ([][]byte, error) {
+	const op = "MemDB.DB.find"
+
+	records, err := db.records(m)
+	if err != nil {
+		return nil, err
+	}
+
+	type row struct {
+		record
+		fields map[string]interface{}
+	}
+
+	rows := []row{}
+	for _, r := range records {
+		fields := map[string]interface{}{}
+		err := json.Unmarshal(r.data, &fields)
+		if err != nil {
+			return nil, ez.New(op, ez.EINTERNAL, "Could not decode stored model", err)
+		}
+
+		if stmt.where == nil || stmt.where.eval(fields) {
+			rows = append(rows, row{r, fields})
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range stmt.orders {
+			c, ok := compare(rows[i].fields[o.Field], rows[j].fields[o.Field])
+			if !ok || c == 0 {
+				continue
+			}
+			if o.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return rows[i].seq < rows[j].seq
+	})
+
+	if stmt.offset > 0 {
+		if stmt.offset >= len(rows) {
+			rows = rows[:0]
+		} else {
+			rows = rows[stmt.offset:]
+		}
+	}
+
+	if stmt.limit > 0 && stmt.limit < len(rows) {
+		rows = rows[:stmt.limit]
+	}
+
+	results := make([][]byte, len(rows))
+	for i, r := range rows {
+		results[i] = r.data
+	}
+
+	return results, nil
+}
+
+// decode resets the model and fills it with the encoded data
+func decode(data []byte, m interfaces.Model) error {
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+
+	return json.Unmarshal(data, m)
+}
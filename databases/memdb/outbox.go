@@ -0,0 +1,134 @@
+package memdb
+
+import (
+	"encoding/json"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// outboxRecord defines an event stored in the outbox
+type outboxRecord struct {
+	entry     interfaces.OutboxEntry
+	delivered bool
+}
+
+// WriteOutbox stores events in the outbox, inside a transaction they are only
+// persisted when it is commited and their IDs are assigned again at that point
+func (db *DB) WriteOutbox(events []interfaces.Event) error {
+	const op = "MemDB.DB.WriteOutbox"
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, event := range events {
+		entry := interfaces.OutboxEntry{
+			ID:      db.outboxOffset() + int64(len(db.outbox)+1),
+			Schema:  event.Schema,
+			ModelID: event.ID,
+			Op:      event.Op,
+			Time:    event.Time,
+		}
+
+		var err error
+		if event.Before != nil {
+			entry.Before, err = json.Marshal(event.Before)
+			if err != nil {
+				return ez.New(op, ez.EINTERNAL, "Could not encode the event", err)
+			}
+		}
+		if event.After != nil {
+			entry.After, err = json.Marshal(event.After)
+			if err != nil {
+				return ez.New(op, ez.EINTERNAL, "Could not encode the event", err)
+			}
+		}
+
+		db.outbox = append(db.outbox, outboxRecord{entry: entry})
+	}
+
+	return nil
+}
+
+// ReadOutbox returns up to limit entries that have not been delivered
+func (db *DB) ReadOutbox(limit int) ([]interfaces.OutboxEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := []interfaces.OutboxEntry{}
+
+	if db.parent != nil {
+		delivered := map[int64]bool{}
+		for _, id := range db.delivered {
+			delivered[id] = true
+		}
+
+		db.parent.mu.RLock()
+		entries = undelivered(entries, db.parent.outbox, limit, delivered)
+		db.parent.mu.RUnlock()
+	}
+
+	return undelivered(entries, db.outbox, limit, nil), nil
+}
+
+// MarkDelivered marks the entries with the provided IDs as delivered
+func (db *DB) MarkDelivered(ids []int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.parent == nil {
+		db.markDelivered(ids)
+		return nil
+	}
+
+	// Inside a transaction the entries of the original database are marked when it
+	// is commited
+	offset := db.outboxOffset()
+	for _, id := range ids {
+		if id > offset {
+			db.markDelivered([]int64{id - offset})
+		} else {
+			db.delivered = append(db.delivered, id)
+		}
+	}
+
+	return nil
+}
+
+// markDelivered marks the entries of the outbox as delivered, the caller must hold
+// the lock
+func (db *DB) markDelivered(ids []int64) {
+	for _, id := range ids {
+		if id > 0 && id <= int64(len(db.outbox)) {
+			db.outbox[id-1].delivered = true
+		}
+	}
+}
+
+// outboxOffset returns the number of entries of the original database that come
+// before the ones written during a transaction, the caller must hold the lock
+func (db *DB) outboxOffset() int64 {
+	if db.parent == nil {
+		return 0
+	}
+
+	db.parent.mu.RLock()
+	defer db.parent.mu.RUnlock()
+
+	return int64(len(db.parent.outbox))
+}
+
+// undelivered appends the entries of the records that have not been delivered until
+// there are limit entries
+func undelivered(entries []interfaces.OutboxEntry, records []outboxRecord, limit int, delivered map[int64]bool) []interfaces.OutboxEntry {
+	for _, r := range records {
+		if len(entries) >= limit {
+			break
+		}
+		if !r.delivered && !delivered[r.entry.ID] {
+			entries = append(entries, r.entry)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,640 @@
+package memdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/query"
+)
+
+// Queries are translated into expressions that are evaluated against the fields of
+// every stored model. Raw conditions use a small subset of SQL:
+//
+//   name = ? AND (age >= 18 OR admin = true) AND email LIKE '%@gmail.com'
+//
+// Supported operators are =, !=, <>, <, <=, >, >=, LIKE, IN (...), IS NULL and
+// IS NOT NULL, which can be combined with AND, OR, NOT and parentheses. Fields
+// are the JSON keys of the stored models. Values can be written in the condition or
+// bound to ? placeholders, a slice bound to IN (?) is expanded.
+
+// statement defines a Query translated into an expression
+type statement struct {
+	where  node
+	orders []query.Order
+	limit  int
+	offset int
+}
+
+// newStatement translates a Query into a statement
+func newStatement(q *query.Query) (*statement, error) {
+	stmt := &statement{}
+	if q == nil {
+		return stmt, nil
+	}
+
+	if q.GetWhere() != nil {
+		where, err := translate(q.GetWhere())
+		if err != nil {
+			return nil, err
+		}
+		stmt.where = where
+	}
+
+	stmt.orders = q.GetOrders()
+	stmt.limit = q.GetLimit()
+	stmt.offset = q.GetOffset()
+
+	return stmt, nil
+}
+
+// node defines an expression that can be evaluated against the fields of a model
+type node interface {
+	eval(fields map[string]interface{}) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(f map[string]interface{}) bool { return n.left.eval(f) && n.right.eval(f) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(f map[string]interface{}) bool { return n.left.eval(f) || n.right.eval(f) }
+
+type notNode struct{ expr node }
+
+func (n notNode) eval(f map[string]interface{}) bool { return !n.expr.eval(f) }
+
+// comparisonNode compares a field against a value
+type comparisonNode struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (n comparisonNode) eval(f map[string]interface{}) bool {
+	v := f[n.field]
+
+	switch n.op {
+	case "=":
+		c, ok := compare(v, n.value)
+		return ok && c == 0
+	case "!=", "<>":
+		c, ok := compare(v, n.value)
+		return ok && c != 0
+	case "<":
+		c, ok := compare(v, n.value)
+		return ok && c < 0
+	case "<=":
+		c, ok := compare(v, n.value)
+		return ok && c <= 0
+	case ">":
+		c, ok := compare(v, n.value)
+		return ok && c > 0
+	case ">=":
+		c, ok := compare(v, n.value)
+		return ok && c >= 0
+	case "LIKE":
+		s, ok := v.(string)
+		pattern, isString := n.value.(string)
+		return ok && isString && like(s, pattern)
+	}
+
+	return false
+}
+
+// inNode checks if a field is equal to any of the values
+type inNode struct {
+	field  string
+	values []interface{}
+}
+
+func (n inNode) eval(f map[string]interface{}) bool {
+	for _, value := range n.values {
+		c, ok := compare(f[n.field], value)
+		if ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nullNode checks if a field is null or missing
+type nullNode struct {
+	field string
+}
+
+func (n nullNode) eval(f map[string]interface{}) bool { return f[n.field] == nil }
+
+// translate builds an expression from a Query condition
+func translate(c query.Condition) (node, error) {
+	const op = "MemDB.translate"
+
+	switch cond := c.(type) {
+	case query.Comparison:
+		value, err := normalize(cond.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch cond.Operator {
+		case query.EQ, query.NEQ:
+			if value == nil {
+				var n node = nullNode{cond.Field}
+				if cond.Operator == query.NEQ {
+					n = notNode{n}
+				}
+				return n, nil
+			}
+		case query.GT, query.GTE, query.LT, query.LTE, query.LIKE:
+		default:
+			msg := fmt.Sprintf("Operator %s is not supported", cond.Operator)
+			return nil, ez.New(op, ez.EINVALID, msg, nil)
+		}
+
+		return comparisonNode{field: cond.Field, op: cond.Operator, value: value}, nil
+	case query.Membership:
+		n := inNode{field: cond.Field}
+		for _, v := range cond.Values {
+			value, err := normalize(v)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, value)
+		}
+		return n, nil
+	case query.Group:
+		if cond.Operator != query.AND && cond.Operator != query.OR {
+			msg := fmt.Sprintf("Operator %s is not supported", cond.Operator)
+			return nil, ez.New(op, ez.EINVALID, msg, nil)
+		}
+
+		// An empty AND is always satisfied while an empty OR never is
+		var n node = constNode(cond.Operator != query.OR)
+		for i, sub := range cond.Conditions {
+			subNode, err := translate(sub)
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case i == 0:
+				n = subNode
+			case cond.Operator == query.OR:
+				n = orNode{n, subNode}
+			default:
+				n = andNode{n, subNode}
+			}
+		}
+		return n, nil
+	case query.Expr:
+		return parse(cond.Expr, cond.Args...)
+	}
+
+	return nil, ez.New(op, ez.EINVALID, "Condition type is not supported", nil)
+}
+
+// constNode is always or never satisfied
+type constNode bool
+
+func (n constNode) eval(f map[string]interface{}) bool { return bool(n) }
+
+// compare returns -1, 0 or 1 comparing a with b. The second value is false when
+// the values can not be compared
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok || x != y {
+			return 1, ok
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// like matches a string against a SQL LIKE pattern
+func like(s, pattern string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), s)
+	return err == nil && matched
+}
+
+// Token types
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+	tokenEOF
+)
+
+type token struct {
+	kind  int
+	value string
+}
+
+// tokenize splits a query into tokens
+func tokenize(query string) ([]token, error) {
+	const op = "MemDB.tokenize"
+
+	tokens := []token{}
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, ez.New(op, ez.EINVALID, "Unterminated string in query", nil)
+				}
+				if runes[i] == '\'' {
+					// Two single quotes are an escaped quote
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						value.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{tokenString, value.String()})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i])})
+		case r == '"':
+			start := i + 1
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, ez.New(op, ez.EINVALID, "Unterminated identifier in query", nil)
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i])})
+			i++
+		case strings.ContainsRune("<>!=", r):
+			if i+1 < len(runes) {
+				switch symbol := string(runes[i : i+2]); symbol {
+				case "<=", ">=", "!=", "<>":
+					tokens = append(tokens, token{tokenSymbol, symbol})
+					i += 2
+					continue
+				}
+			}
+			if r == '!' {
+				return nil, ez.New(op, ez.EINVALID, "Unexpected character ! in query", nil)
+			}
+			tokens = append(tokens, token{tokenSymbol, string(r)})
+			i++
+		case strings.ContainsRune("(),?", r):
+			tokens = append(tokens, token{tokenSymbol, string(r)})
+			i++
+		default:
+			msg := fmt.Sprintf("Unexpected character %c in query", r)
+			return nil, ez.New(op, ez.EINVALID, msg, nil)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// parser builds an expression from a list of tokens
+type parser struct {
+	tokens []token
+	pos    int
+	args   []interface{}
+	arg    int
+}
+
+// parse builds an expression from a raw query condition, binding the args to its
+// placeholders
+func parse(expr string, args ...interface{}) (node, error) {
+	const op = "MemDB.parse"
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, args: args}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		msg := fmt.Sprintf("Unexpected %s in query", p.peek().value)
+		return nil, ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	if p.arg != len(args) {
+		msg := fmt.Sprintf("Query has %d placeholders but %d args were provided", p.arg, len(args))
+		return nil, ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.value, keyword)
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	t := p.peek()
+	if t.kind == tokenSymbol && t.value == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	const op = "MemDB.parse"
+
+	if p.acceptSymbol("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptSymbol(")") {
+			return nil, ez.New(op, ez.EINVALID, "Expected ) in query", nil)
+		}
+		return expr, nil
+	}
+
+	t := p.next()
+	if t.kind != tokenIdent {
+		msg := fmt.Sprintf("Expected a field but found %s in query", t.value)
+		return nil, ez.New(op, ez.EINVALID, msg, nil)
+	}
+	field := t.value
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if !p.acceptKeyword("NULL") {
+			return nil, ez.New(op, ez.EINVALID, "Expected NULL after IS in query", nil)
+		}
+		if not {
+			return notNode{nullNode{field}}, nil
+		}
+		return nullNode{field}, nil
+	}
+
+	not := p.acceptKeyword("NOT")
+
+	if p.acceptKeyword("IN") {
+		if !p.acceptSymbol("(") {
+			return nil, ez.New(op, ez.EINVALID, "Expected ( after IN in query", nil)
+		}
+
+		n := inNode{field: field}
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+
+			list, ok := value.([]interface{})
+			if ok {
+				n.values = append(n.values, list...)
+			} else {
+				n.values = append(n.values, value)
+			}
+
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+
+		if !p.acceptSymbol(")") {
+			return nil, ez.New(op, ez.EINVALID, "Expected ) after IN values in query", nil)
+		}
+
+		if not {
+			return notNode{n}, nil
+		}
+		return n, nil
+	}
+
+	if p.acceptKeyword("LIKE") {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		n := comparisonNode{field: field, op: "LIKE", value: value}
+		if not {
+			return notNode{n}, nil
+		}
+		return n, nil
+	}
+
+	if not {
+		return nil, ez.New(op, ez.EINVALID, "Expected IN or LIKE after NOT in query", nil)
+	}
+
+	t = p.next()
+	switch t.value {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+	default:
+		msg := fmt.Sprintf("Expected an operator after %s in query", field)
+		return nil, ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return comparisonNode{field: field, op: t.value, value: value}, nil
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	const op = "MemDB.parse"
+
+	t := p.next()
+
+	switch t.kind {
+	case tokenSymbol:
+		if t.value == "?" {
+			return p.bind()
+		}
+	case tokenString:
+		return t.value, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, ez.New(op, ez.EINVALID, "Invalid number in query", err)
+		}
+		return f, nil
+	case tokenIdent:
+		switch strings.ToUpper(t.value) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		}
+	}
+
+	msg := fmt.Sprintf("Expected a value but found %s in query", t.value)
+	return nil, ez.New(op, ez.EINVALID, msg, nil)
+}
+
+// bind returns the next arg converted to the same types used by the stored fields
+func (p *parser) bind() (interface{}, error) {
+	const op = "MemDB.parse"
+
+	if p.arg >= len(p.args) {
+		return nil, ez.New(op, ez.EINVALID, "Not enough args for the query placeholders", nil)
+	}
+
+	arg := p.args[p.arg]
+	p.arg++
+
+	return normalize(arg)
+}
+
+// normalize converts a value to the same types used by the stored fields, as models
+// are stored as JSON, values are converted the same way to be comparable
+func normalize(v interface{}) (interface{}, error) {
+	const op = "MemDB.normalize"
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Could not encode query value", err)
+	}
+
+	var value interface{}
+	err = json.Unmarshal(encoded, &value)
+	if err != nil {
+		return nil, ez.New(op, ez.EINVALID, "Could not decode query value", err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,163 @@
+package memdb
+
+import (
+	"fmt"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// overlay defines the writes of a transaction that have not been commited, a nil
+// record means the model was deleted
+type overlay map[string]map[string]*record
+
+// hasTable returns if the table of the model exists, the caller must hold the lock
+func (db *DB) hasTable(name string) bool {
+	if db.parent == nil {
+		_, ok := db.tables[name]
+		return ok
+	}
+
+	if db.reset[name] {
+		return true
+	}
+
+	db.parent.mu.RLock()
+	defer db.parent.mu.RUnlock()
+
+	_, ok := db.parent.tables[name]
+	return ok
+}
+
+// checkTable returns an error if the table where the model is stored does not exist,
+// the caller must hold the lock
+func (db *DB) checkTable(m interfaces.Model) error {
+	const op = "MemDB.DB.table"
+
+	if !db.hasTable(m.GetSchema().Name) {
+		msg := fmt.Sprintf("Table %s does not exist", m.GetSchema().Name)
+		return ez.New(op, ez.EINVALID, msg, nil)
+	}
+
+	return nil
+}
+
+// lookup returns the stored record of the model with the ID. Inside a transaction its
+// own writes are seen before the ones of the original database. The caller must hold
+// the lock
+func (db *DB) lookup(m interfaces.Model, id string) (record, bool, error) {
+	err := db.checkTable(m)
+	if err != nil {
+		return record{}, false, err
+	}
+
+	name := m.GetSchema().Name
+
+	if db.parent == nil {
+		r, ok := db.tables[name][id]
+		return r, ok, nil
+	}
+
+	w, ok := db.writes[name][id]
+	if ok {
+		if w == nil {
+			return record{}, false, nil
+		}
+		return *w, true, nil
+	}
+
+	if db.reset[name] {
+		return record{}, false, nil
+	}
+
+	db.parent.mu.RLock()
+	defer db.parent.mu.RUnlock()
+
+	r, ok := db.parent.tables[name][id]
+	return r, ok, nil
+}
+
+// records returns every stored record of the model table, the caller must hold the
+// lock
+func (db *DB) records(m interfaces.Model) ([]record, error) {
+	err := db.checkTable(m)
+	if err != nil {
+		return nil, err
+	}
+
+	name := m.GetSchema().Name
+	records := []record{}
+
+	if db.parent == nil {
+		for _, r := range db.tables[name] {
+			records = append(records, r)
+		}
+		return records, nil
+	}
+
+	writes := db.writes[name]
+	for _, w := range writes {
+		if w != nil {
+			records = append(records, *w)
+		}
+	}
+
+	if db.reset[name] {
+		return records, nil
+	}
+
+	db.parent.mu.RLock()
+	defer db.parent.mu.RUnlock()
+
+	for id, r := range db.parent.tables[name] {
+		_, written := writes[id]
+		if !written {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+// put stores the record of the model, the caller must hold the lock
+func (db *DB) put(m interfaces.Model, r record) {
+	name := m.GetSchema().Name
+
+	if db.parent == nil {
+		db.tables[name][m.GetID()] = r
+		return
+	}
+
+	db.write(name, m.GetID(), &r)
+}
+
+// remove deletes the record of the model, the caller must hold the lock
+func (db *DB) remove(m interfaces.Model) {
+	name := m.GetSchema().Name
+
+	if db.parent == nil {
+		delete(db.tables[name], m.GetID())
+		return
+	}
+
+	db.write(name, m.GetID(), nil)
+}
+
+// write records a write of the transaction, the caller must hold the lock
+func (db *DB) write(name, id string, r *record) {
+	if db.writes == nil {
+		db.writes = overlay{}
+	}
+
+	if db.writes[name] == nil {
+		db.writes[name] = map[string]*record{}
+	}
+
+	db.writes[name][id] = r
+}
+
+// nextSeq returns the sequence of a new record, the caller must hold the lock
+func (db *DB) nextSeq() int64 {
+	db.seq++
+	return db.seq
+}
@@ -0,0 +1,101 @@
+package pgdb
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// AuditSink defines an audit sink that writes the records to the audit_log table
+type AuditSink struct {
+	db *DB
+}
+
+// NewAuditSink creates a new AuditSink that writes to the audit_log table of db, use
+// CreateAuditLog to create the table
+func NewAuditSink(db *DB) *AuditSink {
+	return &AuditSink{db: db}
+}
+
+// CreateAuditLog creates the audit_log table where the audit records are stored
+func (db *DB) CreateAuditLog() error {
+	const op = "PG.DB.CreateAuditLog"
+
+	_, err := db.conn().Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+		id bigserial PRIMARY KEY,
+		schema_name text NOT NULL,
+		model_id text NOT NULL,
+		op text NOT NULL,
+		actor text NOT NULL,
+		diff jsonb NOT NULL,
+		created_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not create the audit_log table", err)
+	}
+
+	return nil
+}
+
+// Write inserts the records into the audit_log table in a single transaction
+func (s *AuditSink) Write(ctx context.Context, records []interfaces.AuditRecord) error {
+	const op = "PG.AuditSink.Write"
+
+	tx, err := s.db.pg.WithContext(ctx).Begin()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not begin transaction", err)
+	}
+
+	err = insertAuditRecords(tx, records)
+	if err != nil {
+		tx.Rollback()
+		return ez.New(op, ez.EINTERNAL, "Could not write the audit record", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not commit transaction", err)
+	}
+
+	return nil
+}
+
+// SupportsTx returns if the records can be written inside the transactions of db, which
+// must be a PG database
+func (s *AuditSink) SupportsTx(db interfaces.Database) bool {
+	_, ok := db.(*DB)
+	return ok
+}
+
+// WriteTx inserts the records into the audit_log table using the transaction of the
+// changes, which must be a PG transaction
+func (s *AuditSink) WriteTx(ctx context.Context, tx interfaces.TxDatabase, records []interfaces.AuditRecord) error {
+	const op = "PG.AuditSink.WriteTx"
+
+	pgTx, ok := tx.(*DB)
+	if !ok || pgTx.tx == nil {
+		return ez.New(op, ez.EINVALID, "The audit records can only be written in a PG transaction", nil)
+	}
+
+	err := insertAuditRecords(pgTx.tx, records)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not write the audit record", err)
+	}
+
+	return nil
+}
+
+// insertAuditRecords inserts the records into the audit_log table
+func insertAuditRecords(conn orm.DB, records []interfaces.AuditRecord) error {
+	for _, record := range records {
+		_, err := conn.Exec(`INSERT INTO audit_log (schema_name, model_id, op, actor, diff, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			record.Schema, record.ID, record.Op, record.Actor, string(record.Diff), record.Time)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
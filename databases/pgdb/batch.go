@@ -0,0 +1,100 @@
+package pgdb
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// InsertMany adds the models into the database using a single multi-row INSERT
+func (db *DB) InsertMany(models []interfaces.Model) error {
+	const op = "PG.DB.InsertMany"
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	list, err := modelList(models)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	_, err = db.conn().Model(list).Insert()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error inserting %d models into %s", len(models), models[0].GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	return nil
+}
+
+// UpdateMany changes existing models from the database using a single UPDATE that
+// joins the table with the list of new values
+func (db *DB) UpdateMany(models []interfaces.Model) error {
+	const op = "PG.DB.UpdateMany"
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	list, err := modelList(models)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	_, err = db.conn().Model(list).Update()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error updating %d models from %s", len(models), models[0].GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	return nil
+}
+
+// DeleteMany removes existing models from the database using a single DELETE on
+// their primary keys
+func (db *DB) DeleteMany(models []interfaces.Model) error {
+	const op = "PG.DB.DeleteMany"
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	list, err := modelList(models)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	_, err = db.conn().Model(list).WherePK().Delete()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error deleting %d models from %s", len(models), models[0].GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	return nil
+}
+
+// modelList returns a pointer to a slice holding the models, which go-pg uses to
+// build multi-row statements. All of the models must have the same type
+func modelList(models []interfaces.Model) (interface{}, error) {
+	const op = "PG.modelList"
+
+	t := reflect.TypeOf(models[0])
+	if t.Kind() != reflect.Ptr {
+		return nil, ez.New(op, ez.EINVALID, "Models must be pointers to structs", nil)
+	}
+
+	list := reflect.MakeSlice(reflect.SliceOf(t), 0, len(models))
+	for _, m := range models {
+		if reflect.TypeOf(m) != t {
+			return nil, ez.New(op, ez.EINVALID, "All of the models must have the same type", nil)
+		}
+		list = reflect.Append(list, reflect.ValueOf(m))
+	}
+
+	ptr := reflect.New(list.Type())
+	ptr.Elem().Set(list)
+	return ptr.Interface(), nil
+}
@@ -0,0 +1,137 @@
+package pgdb
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// outboxRow defines a row of the outbox table
+type outboxRow struct {
+	ID         int64
+	SchemaName string
+	ModelID    string
+	Op         string
+	Before     string
+	After      string
+	CreatedAt  time.Time
+}
+
+// CreateOutbox creates the state_outbox table where events are stored along with the
+// changes that produced them
+func (db *DB) CreateOutbox() error {
+	const op = "PG.DB.CreateOutbox"
+
+	_, err := db.conn().Exec(`CREATE TABLE IF NOT EXISTS state_outbox (
+		id bigserial PRIMARY KEY,
+		schema_name text NOT NULL,
+		model_id text NOT NULL,
+		op text NOT NULL,
+		before jsonb,
+		after jsonb,
+		created_at timestamptz NOT NULL,
+		delivered_at timestamptz
+	)`)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not create the outbox table", err)
+	}
+
+	_, err = db.conn().Exec(`CREATE INDEX IF NOT EXISTS state_outbox_pending_idx ON state_outbox (id) WHERE delivered_at IS NULL`)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not create the outbox index", err)
+	}
+
+	return nil
+}
+
+// WriteOutbox stores events in the outbox table, inside a transaction they are only
+// persisted when it is commited
+func (db *DB) WriteOutbox(events []interfaces.Event) error {
+	const op = "PG.DB.WriteOutbox"
+
+	for _, event := range events {
+		before, err := encodeOutboxModel(event.Before)
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Could not encode the event", err)
+		}
+
+		after, err := encodeOutboxModel(event.After)
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Could not encode the event", err)
+		}
+
+		_, err = db.conn().Exec(`INSERT INTO state_outbox (schema_name, model_id, op, before, after, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			event.Schema, event.ID, event.Op, before, after, event.Time)
+		if err != nil {
+			return ez.New(op, ez.EINTERNAL, "Could not write the event to the outbox", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadOutbox returns up to limit entries that have not been delivered. Inside a
+// transaction the entries are locked until it ends, and entries locked by other
+// transactions are skipped, so concurrent relays do not read the same entries
+func (db *DB) ReadOutbox(limit int) ([]interfaces.OutboxEntry, error) {
+	const op = "PG.DB.ReadOutbox"
+
+	var rows []outboxRow
+
+	_, err := db.conn().Query(&rows, `SELECT id, schema_name, model_id, op, before, after, created_at FROM state_outbox WHERE delivered_at IS NULL ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not read the outbox", err)
+	}
+
+	entries := make([]interfaces.OutboxEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = interfaces.OutboxEntry{
+			ID:      row.ID,
+			Schema:  row.SchemaName,
+			ModelID: row.ModelID,
+			Op:      row.Op,
+			Time:    row.CreatedAt,
+		}
+		if row.Before != "" {
+			entries[i].Before = json.RawMessage(row.Before)
+		}
+		if row.After != "" {
+			entries[i].After = json.RawMessage(row.After)
+		}
+	}
+
+	return entries, nil
+}
+
+// MarkDelivered marks the entries with the provided IDs as delivered
+func (db *DB) MarkDelivered(ids []int64) error {
+	const op = "PG.DB.MarkDelivered"
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := db.conn().Exec(`UPDATE state_outbox SET delivered_at = now() WHERE id IN (?)`, pg.In(ids))
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not mark the outbox entries as delivered", err)
+	}
+
+	return nil
+}
+
+// encodeOutboxModel encodes a model of an event as JSON, nil models are stored as NULL
+func encodeOutboxModel(m interfaces.Model) (interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
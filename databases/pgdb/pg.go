@@ -1,12 +1,14 @@
 package pgdb
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/go-pg/pg/v9/orm"
 	"github.com/vanclief/ez"
 	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
 )
 
 const (
@@ -21,6 +23,7 @@ const (
 // DB defines a PostgreSQL database that will use go-pg as an ORM
 type DB struct {
 	pg *pg.DB
+	tx *pg.Tx
 }
 
 // New returns a new PG Database instance
@@ -60,6 +63,74 @@ func NewFromURL(url string) (*DB, error) {
 	return &DB{pg: db}, nil
 }
 
+// WithContext returns a DB that runs all of its queries with the context. Queries
+// inside a transaction use the context of the DB that started it
+func (db *DB) WithContext(ctx context.Context) interfaces.Database {
+	if db.tx != nil {
+		return db
+	}
+
+	return &DB{pg: db.pg.WithContext(ctx)}
+}
+
+// Begin starts a new transaction, the returned DB will run all of its operations
+// inside of it until Commit or Rollback are called
+func (db *DB) Begin() (interfaces.TxDatabase, error) {
+	const op = "PG.DB.Begin"
+
+	if db.tx != nil {
+		return nil, ez.New(op, ez.ECONFLICT, "A transaction is already in progress", nil)
+	}
+
+	tx, err := db.pg.Begin()
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not begin transaction", err)
+	}
+
+	return &DB{pg: db.pg, tx: tx}, nil
+}
+
+// Commit persists the changes applied during the transaction
+func (db *DB) Commit() error {
+	const op = "PG.DB.Commit"
+
+	if db.tx == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	err := db.tx.Commit()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not commit transaction", err)
+	}
+
+	return nil
+}
+
+// Rollback discards the changes applied during the transaction
+func (db *DB) Rollback() error {
+	const op = "PG.DB.Rollback"
+
+	if db.tx == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	err := db.tx.Rollback()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not rollback transaction", err)
+	}
+
+	return nil
+}
+
+// conn returns the connection that should be used for queries, which is the
+// transaction if there is one in progress
+func (db *DB) conn() orm.DB {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.pg
+}
+
 // Get returns a single model from the database using its primary key
 func (db *DB) Get(m interfaces.Model, ID interface{}) error {
 	const op = "PG.DB.Get"
@@ -71,9 +142,9 @@ func (db *DB) Get(m interfaces.Model, ID interface{}) error {
 		return ez.New(op, ez.EINVALID, "Can not use provided ID interface type", nil)
 	}
 
-	query := fmt.Sprintf(`SELECT * FROM %s WHERE %s = ?`, m.GetSchema().Name, m.GetSchema().PKey)
+	sql := fmt.Sprintf(`SELECT * FROM %s WHERE %s = ?`, m.GetSchema().Name, m.GetSchema().PKey)
 
-	res, err := db.pg.QueryOne(m, query, ID)
+	res, err := db.conn().QueryOne(m, sql, ID)
 
 	if res != nil && res.RowsReturned() < 1 {
 		msg := fmt.Sprintf("Could not find a %s model with id %s", m.GetSchema().Name, ID)
@@ -95,19 +166,22 @@ func (db *DB) Get(m interfaces.Model, ID interface{}) error {
 
 // QueryOne returns a single model from the database that satisfies a Query.
 // The method will return an error if there is more than one result from the query
-func (db *DB) QueryOne(m interfaces.Model, query string) error {
+func (db *DB) QueryOne(m interfaces.Model, q *query.Query) error {
 	const op = "PG.DB.QueryOne"
 
-	q := fmt.Sprintf(`SELECT * FROM %s WHERE %s`, m.GetSchema().Name, query)
+	sql, args, err := selectSQL(m, q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
 
-	_, err := db.pg.QueryOne(m, q, nil)
+	_, err = db.conn().QueryOne(m, sql, args...)
 	if err != nil {
 		switch err.Error() {
 		case ENOROWS:
-			msg := fmt.Sprintf("Could not find a %s model with query %s", m.GetSchema().Name, query)
+			msg := fmt.Sprintf("Could not find a %s model with query %s", m.GetSchema().Name, sql)
 			return ez.New(op, ez.ENOTFOUND, msg, nil)
 		case EMULTIPLEROWS:
-			msg := fmt.Sprintf("Could find multiple %s models that satisfy QueryOne %s", m.GetSchema().Name, query)
+			msg := fmt.Sprintf("Could find multiple %s models that satisfy QueryOne %s", m.GetSchema().Name, sql)
 			return ez.New(op, ez.ECONFLICT, msg, nil)
 
 		default:
@@ -118,54 +192,45 @@ func (db *DB) QueryOne(m interfaces.Model, query string) error {
 	return nil
 }
 
-// Query returns a list of models from the database that satisfy a Query, extra parameters
-// in the Query allow for Limit and Offset
-func (db *DB) Query(mList interface{}, model interfaces.Model, query []string) error {
+// Query returns a list of models from the database that satisfy a Query
+func (db *DB) Query(mList interface{}, model interfaces.Model, q *query.Query) error {
 	const op = "PG.DB.Query"
 
-	var q string
-
-	switch len(query) {
-	case 1:
-		q = fmt.Sprintf(`SELECT * FROM %s WHERE %s`, model.GetSchema().Name, query[0])
-	case 2:
-		q = fmt.Sprintf(`SELECT * FROM %s WHERE %s LIMIT %s`, model.GetSchema().Name, query[0], query[1])
-	default:
-		q = fmt.Sprintf(`SELECT * FROM %s WHERE %s LIMIT %s OFFSET %s`, model.GetSchema().Name, query[0], query[1], query[2])
+	sql, args, err := selectSQL(model, q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
 	}
 
-	result, err := db.pg.Query(mList, q, nil)
-	if err != nil || result == nil {
+	result, err := db.conn().Query(mList, sql, args...)
+	if err != nil {
 		switch err.Error() {
 		case ENOROWS:
-			msg := fmt.Sprintf("Could not find a %s model with query %s", model.GetSchema().Name, query)
+			msg := fmt.Sprintf("Could not find a %s model with query %s", model.GetSchema().Name, sql)
 			return ez.New(op, ez.ENOTFOUND, msg, nil)
 		default:
 			return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
 		}
 	}
 
-	if result.RowsReturned() == 0 {
-		msg := fmt.Sprintf("Could not find any %s with query %s", model.GetSchema().Name, q)
+	if result == nil || result.RowsReturned() == 0 {
+		msg := fmt.Sprintf("Could not find any %s with query %s", model.GetSchema().Name, sql)
 		return ez.New(op, ez.ENOTFOUND, msg, nil)
 	}
 
 	return nil
 }
 
-// RawQuery returns a list of models from the database that satisfy a Raw Query
-func (db *DB) RawQuery(mList interface{}, model interfaces.Model, rawQuery []string) error {
+// RawQuery returns a list of models from the database that satisfy a Raw Query.
+// Values should be passed as args using ? placeholders in the query
+func (db *DB) RawQuery(mList interface{}, model interfaces.Model, rawQuery string, args ...interface{}) error {
 	const op = "PG.DB.RawQuery"
 
-	result, err := db.pg.Query(mList, rawQuery, nil)
-	if err != nil || result == nil {
-		switch err.Error() {
-		default:
-			return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
-		}
+	result, err := db.conn().Query(mList, rawQuery, args...)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
 	}
 
-	if result.RowsReturned() == 0 {
+	if result == nil || result.RowsReturned() == 0 {
 		msg := fmt.Sprintf("Could not find any %s with query %s", model.GetSchema().Name, rawQuery)
 		return ez.New(op, ez.ENOTFOUND, msg, nil)
 	}
@@ -177,7 +242,7 @@ func (db *DB) RawQuery(mList interface{}, model interfaces.Model, rawQuery []str
 func (db *DB) Insert(m interfaces.Model) error {
 	const op = "PG.DB.Insert"
 
-	err := db.pg.Insert(m)
+	err := db.conn().Insert(m)
 	if err != nil {
 		switch err.Error() {
 		default:
@@ -193,7 +258,7 @@ func (db *DB) Insert(m interfaces.Model) error {
 func (db *DB) Update(m interfaces.Model) error {
 	const op = "PG.DB.Update"
 
-	err := db.pg.Update(m)
+	err := db.conn().Update(m)
 	if err != nil {
 		switch err.Error() {
 		default:
@@ -209,7 +274,7 @@ func (db *DB) Update(m interfaces.Model) error {
 func (db *DB) Delete(m interfaces.Model) error {
 	const op = "PG.DB.Delete"
 
-	err := db.pg.Delete(m)
+	err := db.conn().Delete(m)
 	if err != nil {
 		switch err.Error() {
 		default:
@@ -221,6 +286,39 @@ func (db *DB) Delete(m interfaces.Model) error {
 	return nil
 }
 
+// selectSQL translates a Query into a PostgreSQL SELECT statement and its args
+func selectSQL(m interfaces.Model, q *query.Query) (string, []interface{}, error) {
+	sql := fmt.Sprintf(`SELECT * FROM %s`, m.GetSchema().Name)
+	if q == nil {
+		return sql, nil, nil
+	}
+
+	where, args, err := q.WhereSQL()
+	if err != nil {
+		return "", nil, err
+	}
+	if where != "" {
+		sql += " WHERE " + where
+	}
+
+	order := q.OrderSQL()
+	if order != "" {
+		sql += " ORDER BY " + order
+	}
+
+	if q.GetLimit() > 0 {
+		sql += " LIMIT ?"
+		args = append(args, q.GetLimit())
+	}
+
+	if q.GetOffset() > 0 {
+		sql += " OFFSET ?"
+		args = append(args, q.GetOffset())
+	}
+
+	return sql, args, nil
+}
+
 // CreateSchema creates the database tables if dropExisting is set to true it will drop the current schema
 func (db *DB) CreateSchema(modelsList []interface{}, dropExisting bool) error {
 	const op = "PG.DB.CreateSchema"
@@ -0,0 +1,257 @@
+package sqlitedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vanclief/ez"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// column defines a struct field that is stored as a table column
+type column struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// columns returns the columns of a model struct. The column name is taken from the
+// pg or sql struct tags, otherwise the field name is converted to snake case
+func columns(t reflect.Type) []column {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	cols := []column{}
+	if t.Kind() != reflect.Struct {
+		return cols
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := tagName(f.Tag.Get("pg"))
+		if name == "" {
+			name = tagName(f.Tag.Get("sql"))
+		}
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			for _, c := range columns(f.Type) {
+				c.index = append([]int{i}, c.index...)
+				cols = append(cols, c)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = snakeCase(f.Name)
+		}
+
+		cols = append(cols, column{name: name, index: []int{i}, typ: f.Type})
+	}
+
+	return cols
+}
+
+// tagName returns the column name from a struct tag
+func tagName(tag string) string {
+	name := strings.Split(tag, ",")[0]
+	if strings.Contains(name, ":") {
+		return ""
+	}
+	return name
+}
+
+// snakeCase converts a field name like UserID into user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// sqlType returns the SQLite column type used to store a Go type
+func sqlType(t reflect.Type) string {
+	if t == timeType {
+		return "DATETIME"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sqlType(t.Elem())
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	case reflect.String:
+		return "TEXT"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "BLOB"
+		}
+	}
+
+	// Structs, maps and slices are stored encoded as JSON
+	return "TEXT"
+}
+
+// toValue converts a field into a value that can be stored by SQLite
+func toValue(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return v.Interface(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	}
+
+	encoded, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// setValue assigns a value read from SQLite into a field
+func setValue(field reflect.Value, raw interface{}) error {
+	const op = "SQLite.setValue"
+
+	if raw == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		err := setValue(ptr.Elem(), raw)
+		if err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	if field.Type() == timeType {
+		switch val := raw.(type) {
+		case time.Time:
+			field.Set(reflect.ValueOf(val))
+			return nil
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, val)
+			if err != nil {
+				return ez.New(op, ez.EINTERNAL, "Could not parse stored time", err)
+			}
+			field.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		switch val := raw.(type) {
+		case int64:
+			field.SetBool(val != 0)
+			return nil
+		case bool:
+			field.SetBool(val)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val, ok := raw.(int64); ok {
+			field.SetInt(val)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val, ok := raw.(int64); ok {
+			field.SetUint(uint64(val))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch val := raw.(type) {
+		case float64:
+			field.SetFloat(val)
+			return nil
+		case int64:
+			field.SetFloat(float64(val))
+			return nil
+		}
+	case reflect.String:
+		switch val := raw.(type) {
+		case string:
+			field.SetString(val)
+			return nil
+		case []byte:
+			field.SetString(string(val))
+			return nil
+		}
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Uint8 {
+			if val, ok := raw.([]byte); ok {
+				field.SetBytes(append([]byte{}, val...))
+				return nil
+			}
+		}
+	}
+
+	var encoded []byte
+	switch val := raw.(type) {
+	case string:
+		encoded = []byte(val)
+	case []byte:
+		encoded = val
+	default:
+		msg := fmt.Sprintf("Can not assign a %T value to a %s field", raw, field.Type())
+		return ez.New(op, ez.EINTERNAL, msg, nil)
+	}
+
+	ptr := reflect.New(field.Type())
+	err := json.Unmarshal(encoded, ptr.Interface())
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not decode stored value", err)
+	}
+	field.Set(ptr.Elem())
+
+	return nil
+}
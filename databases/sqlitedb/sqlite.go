@@ -0,0 +1,536 @@
+package sqlitedb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
+)
+
+// conn defines the methods shared by sql.DB and sql.Tx
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DB defines a SQLite database stored in a local file
+type DB struct {
+	sql *sql.DB
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+// New returns a new SQLite Database instance using the file in path, use ":memory:"
+// for a database that only lives in memory
+func New(path string) (*DB, error) {
+	const op = "SQLite.New"
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not open the database", err)
+	}
+
+	// SQLite only supports a single writer, and every connection to ":memory:" is
+	// a different database
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, ez.New(op, ez.EINTERNAL, "Could not connect to the database", err)
+	}
+
+	return &DB{sql: db, ctx: context.Background()}, nil
+}
+
+// Close closes the database file
+func (db *DB) Close() error {
+	return db.sql.Close()
+}
+
+// WithContext returns a DB that runs all of its queries with the context. A
+// transaction started with a context is rolled back if the context is canceled
+func (db *DB) WithContext(ctx context.Context) interfaces.Database {
+	return &DB{sql: db.sql, tx: db.tx, ctx: ctx}
+}
+
+// Begin starts a new transaction, the returned DB will run all of its operations
+// inside of it until Commit or Rollback are called
+func (db *DB) Begin() (interfaces.TxDatabase, error) {
+	const op = "SQLite.DB.Begin"
+
+	if db.tx != nil {
+		return nil, ez.New(op, ez.ECONFLICT, "A transaction is already in progress", nil)
+	}
+
+	tx, err := db.sql.BeginTx(db.ctx, nil)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not begin transaction", err)
+	}
+
+	return &DB{sql: db.sql, tx: tx, ctx: db.ctx}, nil
+}
+
+// Commit persists the changes applied during the transaction
+func (db *DB) Commit() error {
+	const op = "SQLite.DB.Commit"
+
+	if db.tx == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	err := db.tx.Commit()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not commit transaction", err)
+	}
+
+	return nil
+}
+
+// Rollback discards the changes applied during the transaction
+func (db *DB) Rollback() error {
+	const op = "SQLite.DB.Rollback"
+
+	if db.tx == nil {
+		return ez.New(op, ez.EINVALID, "There is no transaction in progress", nil)
+	}
+
+	err := db.tx.Rollback()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not rollback transaction", err)
+	}
+
+	return nil
+}
+
+// conn returns the connection that should be used for queries, which is the
+// transaction if there is one in progress
+func (db *DB) conn() conn {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.sql
+}
+
+// Get returns a single model from the database using its primary key
+func (db *DB) Get(m interfaces.Model, ID interface{}) error {
+	const op = "SQLite.DB.Get"
+
+	switch ID.(type) {
+	case string:
+	case []byte:
+	default:
+		return ez.New(op, ez.EINVALID, "Can not use provided ID interface type", nil)
+	}
+
+	query := fmt.Sprintf(`SELECT * FROM %s WHERE %s = ?`, query.QuoteIdent(m.GetSchema().Name), query.QuoteIdent(m.GetSchema().PKey))
+
+	n, err := db.queryOne(m, query, ID)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
+	}
+
+	if n < 1 {
+		msg := fmt.Sprintf("Could not find a %s model with id %s", m.GetSchema().Name, ID)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return nil
+}
+
+// QueryOne returns a single model from the database that satisfies a Query.
+// The method will return an error if there is more than one result from the query
+func (db *DB) QueryOne(m interfaces.Model, q *query.Query) error {
+	const op = "SQLite.DB.QueryOne"
+
+	stmt, args, err := selectSQL(m, q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	n, err := db.queryOne(m, stmt, args...)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
+	}
+
+	switch {
+	case n < 1:
+		msg := fmt.Sprintf("Could not find a %s model with query %s", m.GetSchema().Name, stmt)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	case n > 1:
+		msg := fmt.Sprintf("Could find multiple %s models that satisfy QueryOne %s", m.GetSchema().Name, stmt)
+		return ez.New(op, ez.ECONFLICT, msg, nil)
+	}
+
+	return nil
+}
+
+// Query returns a list of models from the database that satisfy a Query
+func (db *DB) Query(mList interface{}, model interfaces.Model, q *query.Query) error {
+	const op = "SQLite.DB.Query"
+
+	stmt, args, err := selectSQL(model, q)
+	if err != nil {
+		return ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	n, err := db.query(mList, stmt, args...)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
+	}
+
+	if n == 0 {
+		msg := fmt.Sprintf("Could not find any %s with query %s", model.GetSchema().Name, stmt)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return nil
+}
+
+// RawQuery returns a list of models from the database that satisfy a Raw Query.
+// Values should be passed as args using ? placeholders in the query
+func (db *DB) RawQuery(mList interface{}, model interfaces.Model, rawQuery string, args ...interface{}) error {
+	const op = "SQLite.DB.RawQuery"
+
+	n, err := db.query(mList, rawQuery, args...)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Error making query to the database", err)
+	}
+
+	if n == 0 {
+		msg := fmt.Sprintf("Could not find any %s with query %s", model.GetSchema().Name, rawQuery)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return nil
+}
+
+// Insert adds a model into the database
+func (db *DB) Insert(m interfaces.Model) error {
+	const op = "SQLite.DB.Insert"
+
+	cols := columns(reflect.TypeOf(m))
+	names := make([]string, len(cols))
+	placeholders := make([]string, len(cols))
+
+	args, err := values(m, cols)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error inserting %s into %s", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	for i, c := range cols {
+		names[i] = query.QuoteIdent(c.name)
+		placeholders[i] = "?"
+	}
+
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, query.QuoteIdent(m.GetSchema().Name),
+		strings.Join(names, ", "), strings.Join(placeholders, ", "))
+
+	_, err = db.conn().ExecContext(db.ctx, q, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error inserting %s into %s", m.GetID(), m.GetSchema().Name)
+		if isConstraintError(err) {
+			return ez.New(op, ez.ECONFLICT, errMsg, err)
+		}
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	return nil
+}
+
+// Update changes an existing model from the database
+func (db *DB) Update(m interfaces.Model) error {
+	const op = "SQLite.DB.Update"
+
+	cols := columns(reflect.TypeOf(m))
+	assignments := make([]string, len(cols))
+
+	args, err := values(m, cols)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error updating %s from %s", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	for i, c := range cols {
+		assignments[i] = query.QuoteIdent(c.name) + " = ?"
+	}
+	args = append(args, m.GetID())
+
+	q := fmt.Sprintf(`UPDATE %s SET %s WHERE %s = ?`, query.QuoteIdent(m.GetSchema().Name),
+		strings.Join(assignments, ", "), query.QuoteIdent(m.GetSchema().PKey))
+
+	res, err := db.conn().ExecContext(db.ctx, q, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error updating %s from %s", m.GetID(), m.GetSchema().Name)
+		if isConstraintError(err) {
+			return ez.New(op, ez.ECONFLICT, errMsg, err)
+		}
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		errMsg := fmt.Sprintf("Error updating %s from %s, it does not exist", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, errMsg, nil)
+	}
+
+	return nil
+}
+
+// Delete removes an existing model from the database
+func (db *DB) Delete(m interfaces.Model) error {
+	const op = "SQLite.DB.Delete"
+
+	q := fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, query.QuoteIdent(m.GetSchema().Name), query.QuoteIdent(m.GetSchema().PKey))
+
+	_, err := db.conn().ExecContext(db.ctx, q, m.GetID())
+	if err != nil {
+		errMsg := fmt.Sprintf("Error deleting %s from %s", m.GetID(), m.GetSchema().Name)
+		return ez.New(op, ez.EINTERNAL, errMsg, err)
+	}
+
+	return nil
+}
+
+// CreateSchema creates the database tables if dropExisting is set to true it will drop the current schema
+func (db *DB) CreateSchema(modelsList []interface{}, dropExisting bool) error {
+	const op = "SQLite.DB.CreateSchema"
+	for _, model := range modelsList {
+		if dropExisting {
+			err := db.DropTable(model)
+			if err != nil {
+				return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+			}
+		}
+		err := db.CreateTable(model)
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+		}
+	}
+	return nil
+}
+
+// CreateTable creates a new table in the database from the model struct fields
+func (db *DB) CreateTable(model interface{}) error {
+	const op = "SQLite.DB.CreateTable"
+
+	m, ok := model.(interfaces.Model)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not a Model", nil)
+	}
+
+	cols := columns(reflect.TypeOf(m))
+	if len(cols) == 0 {
+		return ez.New(op, ez.EINVALID, "Model does not have any field to store", nil)
+	}
+
+	definitions := make([]string, len(cols))
+	for i, c := range cols {
+		definitions[i] = query.QuoteIdent(c.name) + " " + sqlType(c.typ)
+		if c.name == m.GetSchema().PKey {
+			definitions[i] += " PRIMARY KEY"
+		}
+	}
+
+	q := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (%s)`, query.QuoteIdent(m.GetSchema().Name), strings.Join(definitions, ", "))
+
+	_, err := db.conn().ExecContext(db.ctx, q)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not create table", err)
+	}
+
+	return nil
+}
+
+// DropTable deletes the existing tables
+func (db *DB) DropTable(model interface{}) error {
+	const op = "SQLite.DB.DropTable"
+
+	m, ok := model.(interfaces.Model)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not a Model", nil)
+	}
+
+	_, err := db.conn().ExecContext(db.ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, query.QuoteIdent(m.GetSchema().Name)))
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not drop table", err)
+	}
+
+	return nil
+}
+
+// selectSQL translates a Query into a SQLite SELECT statement and its args
+func selectSQL(m interfaces.Model, q *query.Query) (string, []interface{}, error) {
+	stmt := fmt.Sprintf(`SELECT * FROM %s`, query.QuoteIdent(m.GetSchema().Name))
+	if q == nil {
+		return stmt, nil, nil
+	}
+
+	where, args, err := q.WhereSQL()
+	if err != nil {
+		return "", nil, err
+	}
+	if where != "" {
+		stmt += " WHERE " + where
+	}
+
+	order := q.OrderSQL()
+	if order != "" {
+		stmt += " ORDER BY " + order
+	}
+
+	// SQLite does not support OFFSET without LIMIT, a negative limit means no limit
+	if q.GetLimit() > 0 || q.GetOffset() > 0 {
+		limit := q.GetLimit()
+		if limit == 0 {
+			limit = -1
+		}
+		stmt += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	if q.GetOffset() > 0 {
+		stmt += " OFFSET ?"
+		args = append(args, q.GetOffset())
+	}
+
+	return stmt, args, nil
+}
+
+// queryOne runs a query and scans the first row into the model, returning the
+// number of rows found
+func (db *DB) queryOne(m interfaces.Model, query string, args ...interface{}) (int, error) {
+	rows, err := db.conn().QueryContext(db.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+		if n > 1 {
+			continue
+		}
+
+		err = scan(rows, reflect.ValueOf(m).Elem())
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, rows.Err()
+}
+
+// query runs a query and scans every row into the list of models, returning the
+// number of rows found
+func (db *DB) query(mList interface{}, query string, args ...interface{}) (int, error) {
+	const op = "SQLite.DB.query"
+
+	list := reflect.ValueOf(mList)
+	if list.Kind() != reflect.Ptr || list.Elem().Kind() != reflect.Slice {
+		return 0, ez.New(op, ez.EINVALID, "The list of models must be a pointer to a slice", nil)
+	}
+	list = list.Elem()
+
+	rows, err := db.conn().QueryContext(db.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	elemType := list.Type().Elem()
+	result := reflect.MakeSlice(list.Type(), 0, 0)
+
+	for rows.Next() {
+		elem := reflect.New(elemType).Elem()
+
+		dest := elem
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elemType.Elem()))
+			dest = elem.Elem()
+		}
+
+		err = scan(rows, dest)
+		if err != nil {
+			return 0, err
+		}
+
+		result = reflect.Append(result, elem)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	list.Set(result)
+	return result.Len(), nil
+}
+
+// scan reads the current row into the struct fields that match its columns
+func scan(rows *sql.Rows, dest reflect.Value) error {
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	raw := make([]interface{}, len(names))
+	ptrs := make([]interface{}, len(names))
+	for i := range raw {
+		ptrs[i] = &raw[i]
+	}
+
+	err = rows.Scan(ptrs...)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]column{}
+	for _, c := range columns(dest.Type()) {
+		fields[c.name] = c
+	}
+
+	dest.Set(reflect.Zero(dest.Type()))
+	for i, name := range names {
+		c, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		err = setValue(dest.FieldByIndex(c.index), raw[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// values returns the values of the model columns
+func values(m interfaces.Model, cols []column) ([]interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(m))
+
+	args := make([]interface{}, len(cols))
+	for i, c := range cols {
+		value, err := toValue(v.FieldByIndex(c.index))
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	return args, nil
+}
+
+// isConstraintError checks if the error was caused by a constraint violation
+func isConstraintError(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.Code == sqlite3.ErrConstraint
+}
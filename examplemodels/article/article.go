@@ -0,0 +1,74 @@
+package article
+
+import (
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// Article is an example model that uses lifecycle hooks to validate itself and keep
+// its timestamps
+type Article struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Published bool      `json:"published"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func New(id, title string) *Article {
+	return &Article{ID: id, Title: title}
+}
+
+func (a *Article) GetSchema() *interfaces.Schema {
+	return &interfaces.Schema{Name: "articles", PKey: "id"}
+}
+
+func (a *Article) GetID() string {
+	return a.ID
+}
+
+func (a *Article) Update(i interface{}) error {
+	const op = "Article.Update"
+
+	article, ok := i.(*Article)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not of type Article", nil)
+	}
+
+	*a = *article
+
+	return nil
+}
+
+func (a *Article) Validate() error {
+	const op = "Article.Validate"
+
+	if a.Title == "" {
+		return ez.New(op, ez.EINVALID, "Article requires a title", nil)
+	}
+
+	return nil
+}
+
+func (a *Article) BeforeInsert() error {
+	a.CreatedAt = time.Now().UTC()
+	a.UpdatedAt = a.CreatedAt
+	return nil
+}
+
+func (a *Article) BeforeUpdate() error {
+	a.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+func (a *Article) BeforeDelete() error {
+	const op = "Article.BeforeDelete"
+
+	if a.Published {
+		return ez.New(op, ez.ECONFLICT, "Published articles can not be deleted", nil)
+	}
+
+	return nil
+}
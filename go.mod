@@ -3,11 +3,13 @@ module github.com/vanclief/state
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/go-pg/pg/v9 v9.1.6
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1
 	github.com/mattn/go-colorable v0.1.6 // indirect
+	github.com/mattn/go-sqlite3 v1.14.10
 	github.com/stretchr/testify v1.5.1
 	github.com/vanclief/ez v1.1.3
 )
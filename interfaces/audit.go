@@ -0,0 +1,35 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AuditRecord defines who applied a change to the application state and what changed
+type AuditRecord struct {
+	Schema string    `json:"schema"`
+	ID     string    `json:"id"`
+	Op     string    `json:"op"`
+	Actor  string    `json:"actor"`
+	Time   time.Time `json:"time"`
+	// Diff contains the before and after values of every field that changed
+	Diff json.RawMessage `json:"diff"`
+}
+
+// AuditSink defines a storage for audit records
+type AuditSink interface {
+	// Write stores the audit records of a commit or rollback
+	Write(context.Context, []AuditRecord) error
+}
+
+// TxAuditSink defines an audit sink that can write the records inside the transaction
+// that persists the changes, so they are commited or discarded together
+type TxAuditSink interface {
+	AuditSink
+	// SupportsTx returns if the records can be written inside the transactions of the
+	// Database, otherwise they are written with Write once the changes are persisted
+	SupportsTx(Database) bool
+	// WriteTx stores the audit records of a commit or rollback using the transaction
+	WriteTx(context.Context, TxDatabase, []AuditRecord) error
+}
@@ -1,5 +1,10 @@
 package interfaces
 
+import (
+	"context"
+	"time"
+)
+
 // Cache defines a cache storage method
 type Cache interface {
 	// Get attempts to retrieve a model using its ID as Key, if found it
@@ -16,3 +21,37 @@ type Cache interface {
 	// Purge clears the cache
 	Purge() error
 }
+
+// ContextCache defines a Cache that supports binding its operations to a context, so
+// they can be canceled or timed out
+type ContextCache interface {
+	Cache
+	// WithContext returns a Cache that runs all of its operations with the context
+	WithContext(context.Context) Cache
+}
+
+// BatchCache defines a Cache that can store or destroy many models with a single
+// round trip
+type BatchCache interface {
+	Cache
+	// SetMany adds the models to the Cache using their IDs as Keys
+	SetMany([]Model, int) error
+	// DeleteMany destroys the models stored in the Cache
+	DeleteMany([]Model) error
+}
+
+// ExpiryCache defines a Cache that can report when a stored model expires
+type ExpiryCache interface {
+	Cache
+	// Expiry returns when the model stored using the ID as Key expires, the zero time
+	// if it never does
+	Expiry(Model, interface{}) (time.Time, error)
+}
+
+// LayeredCache defines a Cache that stores models in an in-process layer in front of a
+// layer shared with other instances
+type LayeredCache interface {
+	Cache
+	// Local returns the in-process layer of the Cache
+	Local() Cache
+}
@@ -1,16 +1,23 @@
 package interfaces
 
+import (
+	"context"
+
+	"github.com/vanclief/state/query"
+)
+
 // Database defines a persistent storage method
 type Database interface {
 	// Get returns a Model from the database using its ID as PK
 	Get(Model, interface{}) error
 	// QueryOne returns a Model from the database that satisfies a Query. Should
 	// return error if it finds more than one model that satisfies the Query
-	QueryOne(Model, string) error
+	QueryOne(Model, *query.Query) error
 	// Query returns all Model from the database that satisfy a Query
-	Query(interface{}, Model, []string) error
-	// RawQuery returns all Model from the database that satisfy a raw SQL Query
-	RawQuery(interface{}, Model, []string) error
+	Query(interface{}, Model, *query.Query) error
+	// RawQuery returns all Model from the database that satisfy a raw SQL Query.
+	// Values are bound to the ? placeholders of the Query
+	RawQuery(interface{}, Model, string, ...interface{}) error
 	// Insert a model into the database using its ID as PK
 	Insert(Model) error
 	// Update an existing model into the database
@@ -21,3 +28,36 @@ type Database interface {
 	// application Models
 	CreateSchema([]interface{}, bool) error
 }
+
+// TxDatabase defines a Database that supports transactions. Changes applied to the
+// Database returned by Begin are only persisted after Commit is called
+type TxDatabase interface {
+	Database
+	// Begin starts a new transaction and returns a Database bound to it
+	Begin() (TxDatabase, error)
+	// Commit persists all the changes applied during the transaction
+	Commit() error
+	// Rollback discards all the changes applied during the transaction
+	Rollback() error
+}
+
+// ContextDatabase defines a Database that supports binding its operations to a
+// context, so they can be canceled or timed out
+type ContextDatabase interface {
+	Database
+	// WithContext returns a Database that runs all of its operations with the context
+	WithContext(context.Context) Database
+}
+
+// BatchDatabase defines a Database that can apply the same operation to many models of
+// a Schema at once. Each operation either succeeds for all of the models or fails for all
+// of them
+type BatchDatabase interface {
+	Database
+	// InsertMany inserts the models into the database
+	InsertMany([]Model) error
+	// UpdateMany updates existing models in the database
+	UpdateMany([]Model) error
+	// DeleteMany deletes existing models from the database
+	DeleteMany([]Model) error
+}
@@ -0,0 +1,28 @@
+package interfaces
+
+import (
+	"context"
+	"time"
+)
+
+// Event defines a change that was commited to the application state
+type Event struct {
+	// Schema is the name of the schema of the changed model
+	Schema string
+	// ID is the ID of the changed model
+	ID string
+	// Op is the operation that was applied: insert, update or delete
+	Op string
+	// Before is the model before the change, nil for inserts or if it is unknown
+	Before Model
+	// After is the model after the change, nil for deletes
+	After Model
+	// Time is when the change was commited
+	Time time.Time
+}
+
+// Publisher defines a transport that delivers events to external consumers
+type Publisher interface {
+	// Publish delivers the events of a commit in the order they were applied
+	Publish(context.Context, []Event) error
+}
@@ -12,3 +12,42 @@ type Schema struct {
 	Name string
 	PKey string
 }
+
+// Validator defines a Model that validates itself before being staged for an insert
+// or update
+type Validator interface {
+	Validate() error
+}
+
+// BeforeInserter defines a Model that runs logic before being inserted, returning an
+// error prevents the insert
+type BeforeInserter interface {
+	BeforeInsert() error
+}
+
+// AfterInserter defines a Model that runs logic after being inserted
+type AfterInserter interface {
+	AfterInsert()
+}
+
+// BeforeUpdater defines a Model that runs logic before being updated, returning an
+// error prevents the update
+type BeforeUpdater interface {
+	BeforeUpdate() error
+}
+
+// AfterUpdater defines a Model that runs logic after being updated
+type AfterUpdater interface {
+	AfterUpdate()
+}
+
+// BeforeDeleter defines a Model that runs logic before being deleted, returning an
+// error prevents the delete
+type BeforeDeleter interface {
+	BeforeDelete() error
+}
+
+// AfterDeleter defines a Model that runs logic after being deleted
+type AfterDeleter interface {
+	AfterDelete()
+}
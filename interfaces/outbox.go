@@ -0,0 +1,31 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEntry defines an event stored in an outbox, models are kept as JSON
+type OutboxEntry struct {
+	// ID is the position of the entry in the outbox
+	ID      int64
+	Schema  string
+	ModelID string
+	Op      string
+	Before  json.RawMessage
+	After   json.RawMessage
+	Time    time.Time
+}
+
+// OutboxDatabase defines a Database that can store events in an outbox, so they are
+// persisted in the same transaction as the changes that produced them
+type OutboxDatabase interface {
+	Database
+	// WriteOutbox stores events in the outbox
+	WriteOutbox([]Event) error
+	// ReadOutbox returns up to limit entries that have not been delivered, in the
+	// order they were stored
+	ReadOutbox(int) ([]OutboxEntry, error)
+	// MarkDelivered marks the entries with the provided IDs as delivered
+	MarkDelivered([]int64) error
+}
@@ -0,0 +1,186 @@
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/go-redis/redis"
+	log "github.com/inconshreveable/log15"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// invalidation defines the message announcing the models changed by an instance
+type invalidation struct {
+	Node string            `json:"node"`
+	Keys []invalidationKey `json:"keys"`
+}
+
+// invalidationKey identifies a changed model
+type invalidationKey struct {
+	Schema string `json:"schema"`
+	ID     string `json:"id"`
+}
+
+// invalidatedModel defines a model that only knows its schema and ID, which is all the
+// caches need to remove it
+type invalidatedModel struct {
+	schema string
+	id     string
+}
+
+func (m *invalidatedModel) GetSchema() *interfaces.Schema {
+	return &interfaces.Schema{Name: m.schema}
+}
+
+func (m *invalidatedModel) GetID() string {
+	return m.id
+}
+
+func (m *invalidatedModel) Update(i interface{}) error {
+	return ez.New("Invalidation.invalidatedModel.Update", ez.EINVALID, "An invalidated model can not be updated", nil)
+}
+
+// Invalidator keeps the in-process caches of several instances consistent. As a
+// Publisher of the Manager it announces the models changed by each commit or rollback
+// on a Redis channel, and once listening it removes the models announced by other
+// instances from the local cache, along with the related entries of their schemas
+type Invalidator struct {
+	Client  *redis.Client
+	channel string
+	cache   interfaces.Cache
+	related func(schema string) interfaces.Model
+	node    string
+}
+
+// New returns an Invalidator that announces changes on the channel and removes the
+// changes announced by other instances from the cache. For every changed schema the
+// entry returned by related is removed too, such as manager.QueryGeneration to discard
+// the cached query results, related can be nil. The cache must be local to the
+// instance, an interfaces.LayeredCache is rejected since every instance would remove
+// the models from its shared layer, its local layer should be used instead
+func New(client *redis.Client, channel string, cache interfaces.Cache, related func(schema string) interfaces.Model) (*Invalidator, error) {
+	const op = "Invalidation.New"
+
+	if client == nil || cache == nil || channel == "" {
+		return nil, ez.New(op, ez.EINVALID, "An invalidator requires a client, a channel and a cache", nil)
+	}
+
+	if _, ok := cache.(interfaces.LayeredCache); ok {
+		return nil, ez.New(op, ez.EINVALID, "An invalidator requires an in-process cache, use the local layer of the cache", nil)
+	}
+
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, ez.New(op, ez.EINTERNAL, "Could not generate the node ID", err)
+	}
+
+	return &Invalidator{Client: client, channel: channel, cache: cache, related: related, node: hex.EncodeToString(id)}, nil
+}
+
+// Node returns the ID that identifies the messages sent by this instance
+func (i *Invalidator) Node() string {
+	return i.node
+}
+
+// Publish announces the models changed by the events on the channel
+func (i *Invalidator) Publish(ctx context.Context, events []interfaces.Event) error {
+	const op = "Invalidation.Invalidator.Publish"
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	msg := invalidation{Node: i.node, Keys: make([]invalidationKey, len(events))}
+	for n, event := range events {
+		msg.Keys[n] = invalidationKey{Schema: event.Schema, ID: event.ID}
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not encode the invalidation message", err)
+	}
+
+	err = i.Client.WithContext(ctx).Publish(i.channel, encoded).Err()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "Could not publish the invalidation message", err)
+	}
+
+	return nil
+}
+
+// Listen subscribes to the channel and removes the models announced by other instances
+// from the cache until the context is done. It returns once the subscription is active
+func (i *Invalidator) Listen(ctx context.Context) error {
+	const op = "Invalidation.Invalidator.Listen"
+
+	pubsub := i.Client.Subscribe(i.channel)
+
+	// Wait for the confirmation, so no message published after Listen returns is missed
+	_, err := pubsub.Receive()
+	if err != nil {
+		pubsub.Close()
+		return ez.New(op, ez.EUNAVAILABLE, "Could not subscribe to the invalidation channel", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				i.invalidate(message.Payload)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// invalidate removes the models of a message and the related entries of their schemas
+// from the cache, messages sent by this instance are ignored since its cache is already
+// up to date
+func (i *Invalidator) invalidate(payload string) {
+	const op = "Invalidation.Invalidator.invalidate"
+
+	msg := invalidation{}
+
+	err := json.Unmarshal([]byte(payload), &msg)
+	if err != nil {
+		log.Error(op, "Error", err.Error())
+		return
+	}
+
+	if msg.Node == i.node {
+		return
+	}
+
+	schemas := map[string]bool{}
+
+	// A failed removal can not be retried, the entry expires with its TTL
+	for _, key := range msg.Keys {
+		i.delete(op, &invalidatedModel{schema: key.Schema, id: key.ID})
+
+		if i.related != nil && !schemas[key.Schema] {
+			i.delete(op, i.related(key.Schema))
+			schemas[key.Schema] = true
+		}
+	}
+}
+
+// delete removes the model from the cache, models that are not cached are ignored
+func (i *Invalidator) delete(op string, m interfaces.Model) {
+	err := i.cache.Delete(m)
+	if err != nil && ez.ErrorCode(err) != ez.ENOTFOUND {
+		log.Error(op, "Schema", m.GetSchema().Name, "ID", m.GetID(), "Error", ez.ErrorMessage(err))
+	}
+}
@@ -0,0 +1,198 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// actorKey is the context key of the actor that applies the changes
+type actorKey struct{}
+
+// WithActor returns a context that identifies who applies the changes, it is stored
+// in the audit records of the changes commited with it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored in the context, empty if there is none
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// SetAuditSink sets the sink where an audit record of every commited or rolled back
+// change is written, nil disables the audit log
+func (m *Manager) SetAuditSink(sink interfaces.AuditSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.auditSink = sink
+}
+
+// getAuditSink returns the audit sink of the Manager
+func (m *Manager) getAuditSink() interfaces.AuditSink {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.auditSink
+}
+
+// audit writes the audit records of the events once the changes are persisted.
+// Failing to write them does not fail the commit, but as the records are lost the
+// error is logged even if detailed logs are disabled
+func (m *Manager) audit(ctx context.Context, events []interfaces.Event) {
+	const op = "Manager.audit"
+
+	sink := m.getAuditSink()
+	if sink == nil || len(events) == 0 {
+		return
+	}
+
+	// The records were already written inside the transaction of the changes
+	if m.auditsInTx(m.database(ctx)) {
+		return
+	}
+
+	// The records that could be built are still written
+	records, err := auditRecords(op, ctx, events)
+	if err != nil {
+		log.Error(op, "Records", len(events)-len(records), "Error", ez.ErrorMessage(err))
+	}
+
+	err = sink.Write(ctx, records)
+	if err != nil {
+		log.Error(op, "Records", len(records), "Error", ez.ErrorMessage(err))
+	}
+}
+
+// auditsInTx returns if the audit records are written inside the transactions of the
+// Database, which requires a sink that implements interfaces.TxAuditSink and supports
+// the Database
+func (m *Manager) auditsInTx(db interfaces.Database) bool {
+	sink, ok := m.getAuditSink().(interfaces.TxAuditSink)
+	if !ok {
+		return false
+	}
+
+	_, isTx := db.(interfaces.TxDatabase)
+	return isTx && sink.SupportsTx(db)
+}
+
+// writeAudit writes the audit records of the changes inside the transaction, so they
+// are only persisted along with the changes and failing to write them fails the commit
+func (m *Manager) writeAudit(ctx context.Context, tx interfaces.TxDatabase, changes []*Change, event func(*Change, time.Time) interfaces.Event) error {
+	const op = "Manager.writeAudit"
+
+	if len(changes) == 0 || !m.auditsInTx(tx) {
+		return nil
+	}
+
+	now := time.Now()
+	events := make([]interfaces.Event, len(changes))
+	for i, change := range changes {
+		events[i] = event(change, now)
+	}
+
+	records, err := auditRecords(op, ctx, events)
+	if err != nil {
+		return err
+	}
+
+	err = m.getAuditSink().(interfaces.TxAuditSink).WriteTx(ctx, tx, records)
+	if err != nil {
+		return ez.New(op, ez.ErrorCode(err), "Could not write the audit records", err)
+	}
+
+	return nil
+}
+
+// auditRecords returns the audit records of the events. If the diff of an event can not
+// be obtained the event is skipped and the error is returned along with the records of
+// the other events
+func auditRecords(op string, ctx context.Context, events []interfaces.Event) ([]interfaces.AuditRecord, error) {
+	actor := ActorFromContext(ctx)
+
+	var diffErr error
+
+	records := make([]interfaces.AuditRecord, 0, len(events))
+	for _, event := range events {
+		diff, err := diffModels(event.Before, event.After)
+		if err != nil {
+			msg := fmt.Sprintf("Could not obtain the diff of %s from %s", event.ID, event.Schema)
+			diffErr = ez.New(op, ez.EINTERNAL, msg, err)
+			continue
+		}
+
+		records = append(records, interfaces.AuditRecord{
+			Schema: event.Schema,
+			ID:     event.ID,
+			Op:     event.Op,
+			Actor:  actor,
+			Time:   event.Time,
+			Diff:   diff,
+		})
+	}
+
+	return records, diffErr
+}
+
+// fieldDiff defines the values of a field before and after a change
+type fieldDiff struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// diffModels returns a JSON object with the before and after values of the fields
+// that are different between the models, a nil model has no fields
+func diffModels(before, after interfaces.Model) (json.RawMessage, error) {
+	beforeFields, err := modelFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := modelFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]fieldDiff{}
+	for field, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[field]) {
+			diff[field] = fieldDiff{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			diff[field] = fieldDiff{After: value}
+		}
+	}
+
+	return json.Marshal(diff)
+}
+
+// modelFields returns the fields of the model as they are encoded in JSON
+func modelFields(m interfaces.Model) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if m == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
+)
+
+// nextBatch returns the contiguous run of changes at the start of the list that share
+// the schema and operation of the first one
+func nextBatch(changes []*Change) []*Change {
+	first := changes[0]
+	schema := first.model.GetSchema().Name
+
+	n := 1
+	for n < len(changes) && changes[n].op == first.op && changes[n].model.GetSchema().Name == schema {
+		n++
+	}
+
+	return changes[:n]
+}
+
+// applyBatch executes changes with the same schema and operation against the database
+// with a single statement. The status of every change reflects the result of the batch
+func applyBatch(db interfaces.BatchDatabase, changes []*Change) error {
+	const op = "Changes.Apply"
+
+	opName := op + "." + strings.ToUpper(changes[0].op)
+
+	models := make([]interfaces.Model, len(changes))
+	for i, change := range changes {
+		models[i] = change.model
+	}
+
+	var err error
+
+	switch changes[0].op {
+	case INSERT:
+		err = db.InsertMany(models)
+	case UPDATE, DELETE:
+		err = captureBatch(db, changes)
+		if err != nil {
+			return ez.New(opName, ez.ErrorCode(err), "Database: Could not capture models before "+changes[0].op, err)
+		}
+
+		if changes[0].op == UPDATE {
+			err = db.UpdateMany(models)
+		} else {
+			err = db.DeleteMany(models)
+		}
+	}
+
+	if err != nil {
+		// The statement fails as a whole, so every change of the batch failed. The
+		// UnitOfWork applies them again one by one to find the one that caused it
+		for _, change := range changes {
+			change.fail(err)
+		}
+		return ez.New(opName, ez.EINTERNAL, "Database: Could not apply batch "+changes[0].op+" operation", err)
+	}
+
+	for _, change := range changes {
+		change.status = SUCCESS
+	}
+
+	return nil
+}
+
+// captureBatch stores a copy of the models of the changes as they currently are in
+// the database, using a single query for the whole batch. If a model does not exist its
+// change fails
+func captureBatch(db interfaces.Database, changes []*Change) error {
+	const op = "Changes.captureBatch"
+
+	model := changes[0].model
+
+	ids := make([]interface{}, len(changes))
+	for i, change := range changes {
+		ids[i] = change.model.GetID()
+	}
+
+	list := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+
+	err := db.Query(list.Interface(), model, query.Where(query.In(model.GetSchema().PKey, ids...)))
+	if err != nil && ez.ErrorCode(err) != ez.ENOTFOUND {
+		for _, change := range changes {
+			change.fail(err)
+		}
+		return err
+	}
+
+	found := map[string]interfaces.Model{}
+	for i := 0; i < list.Elem().Len(); i++ {
+		m := list.Elem().Index(i).Interface().(interfaces.Model)
+		found[m.GetID()] = m
+	}
+
+	for _, change := range changes {
+		before, ok := found[change.model.GetID()]
+		if !ok {
+			msg := fmt.Sprintf("Could not find a %s model with id %s", model.GetSchema().Name, change.model.GetID())
+			err = ez.New(op, ez.ENOTFOUND, msg, nil)
+			change.fail(err)
+			return err
+		}
+
+		change.before = before
+	}
+
+	return nil
+}
+
+// applyCacheBatch executes the changes persisted to the database against a cache that
+// supports batches. Each run of changes that set models or delete them is applied at
+// once, preserving their order
+func applyCacheBatch(cache interfaces.BatchCache, changes []*Change) error {
+	const op = "Changes.Apply"
+
+	var err error
+
+	for len(changes) > 0 {
+		deleting := changes[0].op == DELETE
+
+		n := 1
+		for n < len(changes) && (changes[n].op == DELETE) == deleting {
+			n++
+		}
+
+		run := changes[:n]
+		changes = changes[n:]
+
+		models := make([]interfaces.Model, len(run))
+		for i, change := range run {
+			// Without a database the before-image can only be obtained from the cache
+			if change.before == nil && change.op != INSERT {
+				change.captureCache(cache)
+			}
+			models[i] = change.model
+		}
+
+		var runErr error
+		if deleting {
+			runErr = cache.DeleteMany(models)
+		} else {
+			runErr = cache.SetMany(models, cache.GetTTL())
+		}
+
+		if runErr != nil {
+			// The changes were persisted to the database, so they stay applied
+			for _, change := range run {
+				change.cacheFailed(cache, change.status, runErr)
+			}
+
+			if deleting {
+				err = ez.New(op+".DELETE", ez.EINTERNAL, "Cache: Could not apply batch delete operation", runErr)
+			} else {
+				err = ez.New(op+".SET", ez.EINTERNAL, "Cache: Could not apply batch set operation", runErr)
+			}
+			continue
+		}
+
+		for _, change := range run {
+			change.status = SUCCESS
+		}
+	}
+
+	return err
+}
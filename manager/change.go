@@ -1,6 +1,9 @@
 package manager
 
 import (
+	"reflect"
+	"strings"
+
 	"github.com/vanclief/ez"
 	"github.com/vanclief/state/interfaces"
 )
@@ -22,10 +25,13 @@ const (
 
 // Change defines the current state of a model that has been staged for changed
 type Change struct {
-	model  interfaces.Model
-	op     string
-	status string
-	err    error
+	model       interfaces.Model
+	before      interfaces.Model
+	op          string
+	status      string
+	err         error
+	cacheErr    error
+	annotations map[string]interface{}
 }
 
 // NewChange creates a new Change struct which is reponsible for tracking changes applied
@@ -44,115 +50,366 @@ func NewChange(m interfaces.Model, operation string) (*Change, error) {
 		return nil, ez.New(op, ez.EINVALID, "Operation type is not suported", nil)
 	}
 
-	return &Change{model: m, op: operation, status: "pending"}, nil
+	return &Change{model: m, op: operation, status: PENDING}, nil
 }
 
-// Apply executes a pending change
-func (ch *Change) Apply(db interfaces.Database, cache interfaces.Cache) error {
-	const op = "Changes.Apply"
+// Model returns the model that is changed
+func (ch *Change) Model() interfaces.Model {
+	return ch.model
+}
 
+// Operation returns the operation applied to the model
+func (ch *Change) Operation() string {
+	return ch.op
+}
+
+// Status returns the current status of the change
+func (ch *Change) Status() string {
+	return ch.status
+}
+
+// Err returns the error of the last attempt to apply or revert the change
+func (ch *Change) Err() error {
+	return ch.err
+}
+
+// CacheErr returns the error of the last attempt to update the cache after the change
+// was persisted to the database, the change stays applied even if the cache failed
+func (ch *Change) CacheErr() error {
+	return ch.cacheErr
+}
+
+// Before returns the model as it was before the change was applied, nil if it is
+// unknown or the change is an insert
+func (ch *Change) Before() interfaces.Model {
+	return ch.before
+}
+
+// Annotate attaches a value to the change, so hooks can share information about it
+func (ch *Change) Annotate(key string, value interface{}) {
+	if ch.annotations == nil {
+		ch.annotations = map[string]interface{}{}
+	}
+
+	ch.annotations[key] = value
+}
+
+// Annotations returns the values attached to the change
+func (ch *Change) Annotations() map[string]interface{} {
+	annotations := make(map[string]interface{}, len(ch.annotations))
+	for key, value := range ch.annotations {
+		annotations[key] = value
+	}
+
+	return annotations
+}
+
+// inheritAnnotations copies the annotations of a change merged into this one, the
+// values already attached to this change are kept
+func (ch *Change) inheritAnnotations(merged *Change) {
+	for key, value := range merged.annotations {
+		if _, ok := ch.annotations[key]; !ok {
+			ch.Annotate(key, value)
+		}
+	}
+}
+
+// Apply executes a pending change. The before and after hooks of the model are run
+// around the operation
+func (ch *Change) Apply(db interfaces.Database, cache interfaces.Cache) error {
 	// Ignore changes that have been successfuly applied or reverted
-	if ch.status == "success" || ch.status == "reverted" {
+	if ch.status == SUCCESS || ch.status == REVERTED {
 		return nil
 	}
 
-	switch ch.op {
-	case INSERT:
-		if db != nil {
-			err := db.Insert(ch.model)
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".INSERT", ez.EINTERNAL, "Database: Could not apply insert operation", err)
-			}
-			ch.status = SUCCESS
+	err := ch.beforeApply()
+	if err != nil {
+		return err
+	}
+
+	if db != nil {
+		err := ch.applyDB(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cache != nil {
+		err = ch.applyCache(cache)
+		if err != nil && db == nil {
+			return err
 		}
 
-		if cache != nil {
-			err := cache.Set(ch.model, cache.GetTTL())
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".INSERT", ez.EINTERNAL, "Cache: Could not apply set operation", err)
-			}
-			ch.status = SUCCESS
+		// The change was persisted to the database, so it is applied even if the
+		// cache failed
+		if err != nil {
+			ch.cacheFailed(cache, SUCCESS, err)
+		}
+	}
+
+	ch.afterApply()
+	return err
+}
+
+// applyDB executes the change against the database
+func (ch *Change) applyDB(db interfaces.Database) error {
+	const op = "Changes.Apply"
+
+	var err error
+
+	switch ch.op {
+	case INSERT:
+		err = db.Insert(ch.model)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".INSERT", ez.EINTERNAL, "Database: Could not apply insert operation", err)
 		}
 	case UPDATE:
-		if db != nil {
-			err := db.Update(ch.model)
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".UPDATE", ez.EINTERNAL, "Database: Could not apply update operation", err)
-			}
-			ch.status = SUCCESS
+		err = ch.captureDB(db)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".UPDATE", ez.ErrorCode(err), "Database: Could not capture model before update", err)
 		}
 
-		if cache != nil {
-			err := cache.Set(ch.model, cache.GetTTL())
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".UPDATE", ez.EINTERNAL, "Cache: Could not apply set operation", err)
-			}
-			ch.status = SUCCESS
+		err = db.Update(ch.model)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".UPDATE", ez.EINTERNAL, "Database: Could not apply update operation", err)
 		}
 	case DELETE:
-		if db != nil {
-			err := db.Delete(ch.model)
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".DELETE", ez.EINTERNAL, "Database: Could not apply delete operation", err)
-			}
-			ch.status = SUCCESS
+		err = ch.captureDB(db)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".DELETE", ez.ErrorCode(err), "Database: Could not capture model before delete", err)
 		}
 
-		if cache != nil {
-			err := cache.Delete(ch.model)
-			if err != nil {
-				ch.status = FAILURE
-				ch.err = err
-				return ez.New(op+".DELETE", ez.EINTERNAL, "Cache: Could not apply delete operation", err)
-			}
-			ch.status = SUCCESS
+		err = db.Delete(ch.model)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".DELETE", ez.EINTERNAL, "Database: Could not apply delete operation", err)
 		}
 	}
 
+	ch.status = SUCCESS
 	return nil
 }
 
-// Revert executes the reverse action of a change, currently only supports insert
-func (ch *Change) Revert(db interfaces.Database, cache interfaces.Cache) error {
-	const op = "Changes.Revert"
+// applyCache executes the change against the cache
+func (ch *Change) applyCache(cache interfaces.Cache) error {
+	const op = "Changes.Apply"
+
+	var err error
+
+	// Without a database the before-image can only be obtained from the cache
+	if ch.before == nil && ch.op != INSERT {
+		ch.captureCache(cache)
+	}
+
+	switch ch.op {
+	case INSERT:
+		err = cache.Set(ch.model, cache.GetTTL())
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".INSERT", ez.EINTERNAL, "Cache: Could not apply set operation", err)
+		}
+	case UPDATE:
+		err = cache.Set(ch.model, cache.GetTTL())
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".UPDATE", ez.EINTERNAL, "Cache: Could not apply set operation", err)
+		}
+	case DELETE:
+		err = cache.Delete(ch.model)
+		if err != nil {
+			ch.fail(err)
+			return ez.New(op+".DELETE", ez.EINTERNAL, "Cache: Could not apply delete operation", err)
+		}
+	}
+
+	ch.status = SUCCESS
+	return nil
+}
+
+// captureDB stores a copy of the model as it currently is in the database, so the
+// change can be reverted later
+func (ch *Change) captureDB(db interfaces.Database) error {
+	before := newModel(ch.model)
+
+	err := db.Get(before, ch.model.GetID())
+	if err != nil {
+		return err
+	}
+
+	ch.before = before
+	return nil
+}
+
+// captureCache stores a copy of the model as it currently is in the cache, if the
+// model is not cached there is nothing to capture
+func (ch *Change) captureCache(cache interfaces.Cache) {
+	before := newModel(ch.model)
 
+	err := cache.Get(before, ch.model.GetID())
+	if err != nil {
+		return
+	}
+
+	ch.before = before
+}
+
+// cacheFailed records that the cache could not be updated after the change was applied
+// to or reverted from the database. The change keeps the status of the database, and
+// the model is evicted so the cache does not serve a stale copy
+func (ch *Change) cacheFailed(cache interfaces.Cache, status string, err error) {
+	ch.status = status
+	ch.err = nil
+	ch.cacheErr = err
+
+	// Evicting is best effort, the cache already failed once
+	cache.Delete(ch.model)
+}
+
+// fail marks the change as failed with the provided error
+func (ch *Change) fail(err error) {
+	ch.status = FAILURE
+	ch.err = err
+}
+
+// Revert executes the reverse action of a change, update and delete changes are
+// reverted by restoring the model as it was before the change was applied
+func (ch *Change) Revert(db interfaces.Database, cache interfaces.Cache) error {
 	// Ignore changes that have not been successfuly applied
-	if ch.status != "success" {
+	if ch.status != SUCCESS {
 		return nil
 	}
 
+	if db != nil {
+		err := ch.revertDB(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cache != nil {
+		err := ch.revertCache(cache)
+		if err != nil && db != nil {
+			// The change was reverted from the database, so it is reverted even if the
+			// cache failed and a later rollback does not revert it again
+			ch.cacheFailed(cache, REVERTED, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revertDB executes the reverse action of the change against the database
+func (ch *Change) revertDB(db interfaces.Database) error {
+	const op = "Changes.Revert"
+
+	var err error
+
+	switch ch.op {
+	case INSERT:
+		err = db.Delete(ch.model)
+		if err != nil {
+			ch.err = err
+			return ez.New(op+".INSERT", ez.EINTERNAL, "Database: Could revert insert operation", err)
+		}
+	case UPDATE:
+		if ch.before == nil {
+			return ez.New(op+".UPDATE", ez.EINTERNAL, "Database: Could not revert update operation without a previous state", nil)
+		}
+
+		err = db.Update(ch.before)
+		if err != nil {
+			ch.err = err
+			return ez.New(op+".UPDATE", ez.EINTERNAL, "Database: Could not revert update operation", err)
+		}
+	case DELETE:
+		if ch.before == nil {
+			return ez.New(op+".DELETE", ez.EINTERNAL, "Database: Could not revert delete operation without a previous state", nil)
+		}
+
+		err = db.Insert(ch.before)
+		if err != nil {
+			ch.err = err
+			return ez.New(op+".DELETE", ez.EINTERNAL, "Database: Could not revert delete operation", err)
+		}
+	}
+
+	ch.status = REVERTED
+	return nil
+}
+
+// revertCache executes the reverse action of the change against the cache
+func (ch *Change) revertCache(cache interfaces.Cache) error {
+	const op = "Changes.Revert"
+
+	var err error
+
 	switch ch.op {
 	case INSERT:
-		if db != nil {
-			err := db.Delete(ch.model)
-			if err != nil {
-				ch.status = SUCCESS
-				ch.err = err
-				return ez.New(op+".INSERT", ez.EINTERNAL, "Database: Could revert insert operation", err)
-			}
-			ch.status = REVERTED
+		err = cache.Delete(ch.model)
+		if err != nil {
+			ch.status = SUCCESS
+			ch.err = err
+			return ez.New(op+".INSERT", ez.EINTERNAL, "Cache: Could revert set operation", err)
+		}
+	case UPDATE, DELETE:
+		// If there is no previous state, removing the model from the cache is the
+		// only way to avoid serving a stale copy
+		if ch.before == nil {
+			err = cache.Delete(ch.model)
+		} else {
+			err = cache.Set(ch.before, cache.GetTTL())
 		}
 
-		if cache != nil {
-			err := cache.Delete(ch.model)
-			if err != nil {
-				ch.status = SUCCESS
-				ch.err = err
-				return ez.New(op+".INSERT", ez.EINTERNAL, "Cache: Could revert set operation", err)
-			}
-			ch.status = REVERTED
+		if err != nil {
+			ch.status = SUCCESS
+			ch.err = err
+			return ez.New(op+"."+strings.ToUpper(ch.op), ez.EINTERNAL, "Cache: Could not restore previous state", err)
 		}
 	}
 
+	ch.status = REVERTED
 	return nil
 }
+
+// dryRun returns a copy of the change that hooks can run on without modifying it
+func (ch *Change) dryRun() *Change {
+	return &Change{
+		model:       cloneModel(ch.model),
+		before:      ch.before,
+		op:          ch.op,
+		status:      ch.status,
+		annotations: ch.Annotations(),
+	}
+}
+
+// cloneModel returns a shallow copy of the model. Unlike copyModel every field is
+// copied, including the ones that are not encoded, but maps, slices and pointers
+// inside the model are shared with the original
+func cloneModel(m interfaces.Model) interfaces.Model {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return m
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+
+	return c.Interface().(interfaces.Model)
+}
+
+// newModel returns a new empty instance with the same type as the provided model
+func newModel(m interfaces.Model) interfaces.Model {
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(interfaces.Model)
+	}
+
+	return reflect.New(t).Elem().Interface().(interfaces.Model)
+}
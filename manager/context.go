@@ -0,0 +1,38 @@
+package manager
+
+import (
+	"context"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// database returns the Database bound to the context if it supports it
+func (m *Manager) database(ctx context.Context) interfaces.Database {
+	db, ok := m.DB.(interfaces.ContextDatabase)
+	if ok {
+		return db.WithContext(ctx)
+	}
+
+	return m.DB
+}
+
+// cache returns the Cache bound to the context if it supports it
+func (m *Manager) cache(ctx context.Context) interfaces.Cache {
+	cache, ok := m.Cache.(interfaces.ContextCache)
+	if ok {
+		return cache.WithContext(ctx)
+	}
+
+	return m.Cache
+}
+
+// checkContext returns an error if the context is canceled or its deadline passed
+func checkContext(op string, ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return ez.New(op, ez.EINTERNAL, "The context of the operation is done", err)
+	}
+
+	return nil
+}
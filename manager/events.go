@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// Subscribe returns a channel that receives an event for every change commited or
+// rolled back through the Manager, and a function that cancels the subscription and
+// closes the channel. Commits never wait for subscribers, so events are dropped while
+// the buffer of the channel is full and counted by DroppedEvents. The buffer must hold
+// at least one event
+func (m *Manager) Subscribe(buffer int) (<-chan interfaces.Event, func(), error) {
+	const op = "Manager.Subscribe"
+
+	if buffer < 1 {
+		return nil, nil, ez.New(op, ez.EINVALID, "Subscribing requires a buffer of at least one event", nil)
+	}
+
+	ch := make(chan interfaces.Event, buffer)
+
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, ch)
+	m.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			for i, sub := range m.subscribers {
+				if sub == ch {
+					m.subscribers = append(m.subscribers[:i:i], m.subscribers[i+1:]...)
+					break
+				}
+			}
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe, nil
+}
+
+// DroppedEvents returns how many events were not delivered to a subscriber because the
+// buffer of its channel was full
+func (m *Manager) DroppedEvents() int64 {
+	return atomic.LoadInt64(&m.dropped)
+}
+
+// AddPublisher registers a Publisher that receives the events of every commit and
+// rollback, so they can be delivered to external consumers
+func (m *Manager) AddPublisher(p interfaces.Publisher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.publishers = append(m.publishers, p)
+}
+
+// hasListeners returns if there are subscribers or publishers waiting for events
+func (m *Manager) hasListeners() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.subscribers) > 0 || len(m.publishers) > 0
+}
+
+// publish delivers the events to the subscribers and publishers. Failing to publish
+// does not fail the commit, as the changes are already persisted
+func (m *Manager) publish(ctx context.Context, events []interfaces.Event) {
+	const op = "Manager.publish"
+
+	if len(events) == 0 {
+		return
+	}
+
+	dropped := 0
+
+	m.mu.RLock()
+	for _, event := range events {
+		for _, sub := range m.subscribers {
+			select {
+			case sub <- event:
+			default:
+				dropped++
+			}
+		}
+	}
+	publishers := m.publishers
+	m.mu.RUnlock()
+
+	if dropped > 0 {
+		atomic.AddInt64(&m.dropped, int64(dropped))
+		m.log(op, "Dropped", dropped)
+	}
+
+	for _, p := range publishers {
+		err := p.Publish(ctx, events)
+		m.logError(op, err, "Events", len(events))
+	}
+}
+
+// commitEvent returns the event of a change that was applied
+func commitEvent(change *Change, t time.Time) interfaces.Event {
+	event := interfaces.Event{
+		Schema: change.model.GetSchema().Name,
+		ID:     change.model.GetID(),
+		Op:     change.op,
+		Time:   t,
+	}
+
+	if change.before != nil {
+		event.Before = copyModel(change.before)
+	}
+	if change.op != DELETE {
+		event.After = copyModel(change.model)
+	}
+
+	return event
+}
+
+// rollbackEvent returns the event of a change that was reverted, which is the
+// opposite operation of the change
+func rollbackEvent(change *Change, t time.Time) interfaces.Event {
+	event := interfaces.Event{
+		Schema: change.model.GetSchema().Name,
+		ID:     change.model.GetID(),
+		Time:   t,
+	}
+
+	switch change.op {
+	case INSERT:
+		event.Op = DELETE
+		event.Before = copyModel(change.model)
+	case UPDATE:
+		event.Op = UPDATE
+		event.Before = copyModel(change.model)
+		if change.before != nil {
+			event.After = copyModel(change.before)
+		}
+	case DELETE:
+		event.Op = INSERT
+		if change.before != nil {
+			event.After = copyModel(change.before)
+		}
+	}
+
+	return event
+}
+
+// copyModel returns a copy of the model, so consumers are not affected if it is
+// modified after the commit
+func copyModel(m interfaces.Model) interfaces.Model {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m
+	}
+
+	c := newModel(m)
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return m
+	}
+
+	return c
+}
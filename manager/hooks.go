@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"context"
+
+	"github.com/vanclief/ez"
+)
+
+// Hooks defines functions that are run around the changes handled by a Manager. Any
+// of the functions can be nil, hooks that return an error veto the operation.
+// BeforeStage, BeforeCommit and AfterApply are run while the UnitOfWork is locked, so
+// they must not call its methods
+type Hooks struct {
+	// BeforeStage is run before a change is staged, returning an error prevents it
+	BeforeStage func(ctx context.Context, change *Change) error
+	// BeforeCommit is run before the staged changes are applied, returning an error
+	// prevents all of them
+	BeforeCommit func(ctx context.Context, changes []*Change) error
+	// AfterApply is run after each change is applied, with transactions it is run
+	// after the transaction is commited
+	AfterApply func(ctx context.Context, change *Change)
+	// AfterCommit is run after a commit with the applied changes and the commit error
+	AfterCommit func(ctx context.Context, applied []*Change, err error)
+	// OnRollback is run after a rollback with the reverted changes and the rollback error
+	OnRollback func(ctx context.Context, reverted []*Change, err error)
+}
+
+// Use registers hooks in the Manager, hooks are run in the order they were registered
+// and the first one that returns an error stops the chain
+func (m *Manager) Use(hooks Hooks) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hooks)
+}
+
+// registeredHooks returns the hooks registered in the Manager
+func (m *Manager) registeredHooks() []Hooks {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.hooks
+}
+
+// beforeStage runs the BeforeStage hooks
+func (m *Manager) beforeStage(ctx context.Context, change *Change) error {
+	const op = "Manager.beforeStage"
+
+	for _, h := range m.registeredHooks() {
+		if h.BeforeStage == nil {
+			continue
+		}
+
+		err := h.BeforeStage(ctx, change)
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), "A hook prevented the change from being staged", err)
+		}
+	}
+
+	return nil
+}
+
+// beforeCommit runs the BeforeCommit hooks
+func (m *Manager) beforeCommit(ctx context.Context, changes []*Change) error {
+	const op = "Manager.beforeCommit"
+
+	for _, h := range m.registeredHooks() {
+		if h.BeforeCommit == nil {
+			continue
+		}
+
+		err := h.BeforeCommit(ctx, changes)
+		if err != nil {
+			return ez.New(op, ez.ErrorCode(err), "A hook prevented the changes from being commited", err)
+		}
+	}
+
+	return nil
+}
+
+// afterApply runs the AfterApply hooks
+func (m *Manager) afterApply(ctx context.Context, change *Change) {
+	for _, h := range m.registeredHooks() {
+		if h.AfterApply != nil {
+			h.AfterApply(ctx, change)
+		}
+	}
+}
+
+// afterCommit runs the AfterCommit hooks
+func (m *Manager) afterCommit(ctx context.Context, applied []*Change, err error) {
+	for _, h := range m.registeredHooks() {
+		if h.AfterCommit != nil {
+			h.AfterCommit(ctx, applied, err)
+		}
+	}
+}
+
+// onRollback runs the OnRollback hooks
+func (m *Manager) onRollback(ctx context.Context, reverted []*Change, err error) {
+	for _, h := range m.registeredHooks() {
+		if h.OnRollback != nil {
+			h.OnRollback(ctx, reverted, err)
+		}
+	}
+}
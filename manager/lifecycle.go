@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"strings"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// validate runs the validation of the model if it implements interfaces.Validator,
+// models staged to be deleted are not validated
+func (ch *Change) validate() error {
+	if ch.op == DELETE {
+		return nil
+	}
+
+	v, ok := ch.model.(interfaces.Validator)
+	if !ok {
+		return nil
+	}
+
+	return v.Validate()
+}
+
+// beforeApply runs the before hook of the model for the operation of the change, if
+// the hook fails the change is marked as failed
+func (ch *Change) beforeApply() error {
+	const op = "Changes.Apply"
+
+	var err error
+
+	switch ch.op {
+	case INSERT:
+		if h, ok := ch.model.(interfaces.BeforeInserter); ok {
+			err = h.BeforeInsert()
+		}
+	case UPDATE:
+		if h, ok := ch.model.(interfaces.BeforeUpdater); ok {
+			err = h.BeforeUpdate()
+		}
+	case DELETE:
+		if h, ok := ch.model.(interfaces.BeforeDeleter); ok {
+			err = h.BeforeDelete()
+		}
+	}
+
+	if err != nil {
+		ch.fail(err)
+		return ez.New(op+"."+strings.ToUpper(ch.op), ez.ErrorCode(err), "Model: Before hook prevented the operation", err)
+	}
+
+	return nil
+}
+
+// afterApply runs the after hook of the model for the operation of the change
+func (ch *Change) afterApply() {
+	switch ch.op {
+	case INSERT:
+		if h, ok := ch.model.(interfaces.AfterInserter); ok {
+			h.AfterInsert()
+		}
+	case UPDATE:
+		if h, ok := ch.model.(interfaces.AfterUpdater); ok {
+			h.AfterUpdate()
+		}
+	case DELETE:
+		if h, ok := ch.model.(interfaces.AfterDeleter); ok {
+			h.AfterDelete()
+		}
+	}
+}
@@ -1,20 +1,38 @@
 package manager
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/vanclief/ez"
 	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
 )
 
-// Manager defines an application state controller
+// Manager defines an application state controller. It is safe to share a Manager
+// between goroutines as long as its Database and Cache are, changes should be staged
+// in a UnitOfWork obtained from Begin()
 type Manager struct {
-	DB             interfaces.Database
-	Cache          interfaces.Cache
-	stagedChanges  []*Change
-	appliedChanges []*Change
-	logging        bool
+	dropped     int64 // Accessed atomically, first so it is aligned on 32-bit platforms
+	DB          interfaces.Database
+	Cache       interfaces.Cache
+	work        *UnitOfWork
+	mu          sync.RWMutex
+	hooks       []Hooks
+	subscribers []chan interfaces.Event
+	publishers  []interfaces.Publisher
+	auditSink   interfaces.AuditSink
+	flights     flightGroup
+	writes      writeGuard
+	fetchTimes  map[string]time.Duration
+	refreshBeta float64
+	logging     bool
+	queryCache  bool
+	outbox      bool
+	coalesce    bool
 }
 
 // New creates a new Application State Manager from storage. It supports using a Database
@@ -26,36 +44,91 @@ func New(db interfaces.Database, cache interfaces.Cache) (*Manager, error) {
 		return nil, ez.New(op, ez.EINVALID, "Creating a State Manager requires at least a database or a cache", nil)
 	}
 
-	return &Manager{DB: db, Cache: cache}, nil
+	m := &Manager{DB: db, Cache: cache}
+	m.work = newUnitOfWork(m)
+
+	return m, nil
 }
 
 // Get obtains a model from the database using its ID, will attempt to fetch it
-// first from Cache and then from Database
-func (m *Manager) Get(model interfaces.Model, id interface{}) error {
+// first from Cache and then from Database. Models obtained from the Database are
+// added to the Cache. The options allow to skip or refresh the Cache. Concurrent
+// reads of the same model that miss the Cache share a single Database read, each of
+// them receiving its own copy of the model. A model read from the Database is not added
+// to the Cache if a commit or rollback of the same schema ran during the read, so it
+// does not overwrite a newer copy. Only writes of this Manager are detected, a commit of
+// another instance during the read can still be overwritten until the model expires
+func (m *Manager) Get(model interfaces.Model, id interface{}, opts ...GetOption) error {
+	return m.GetContext(context.Background(), model, id, opts...)
+}
+
+// GetContext is like Get but the Database and Cache operations use the context
+func (m *Manager) GetContext(ctx context.Context, model interfaces.Model, id interface{}, opts ...GetOption) error {
 	const op = "Manager.Select"
 
-	var err error
-	var inCache bool
+	err := checkContext(op, ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.database(ctx)
+	cache := m.cache(ctx)
+	var inCache, refreshing bool
+
+	o := newGetOptions(opts)
+
+	// Without a database the cache is the only source
+	useCache := cache != nil && (db == nil || !o.skipCache)
 
-	if m.Cache != nil {
+	if useCache && (db == nil || !o.refreshCache) {
 		m.log(op, "Source", "Cache", "ID", id)
 		inCache = true
 
-		err = m.Cache.Get(model, id)
+		err = cache.Get(model, id)
 		if err != nil {
 			m.logError(op, err, "Source", "Cache", "ID", id)
 			inCache = false
+		} else if db != nil && m.shouldRefresh(cache, model, id) {
+			m.log(op, "Source", "DB", "ID", id, "Refresh", "Early")
+			inCache = false
+			refreshing = true
 		}
 	}
 
-	if m.DB != nil && !inCache {
-		m.log(op, "Source", "DB", "ID", id)
-		err = m.DB.Get(model, id)
-		m.logError(op, err, "Source", "DB", "ID", id)
+	if db != nil && !inCache {
+		schema := model.GetSchema().Name
+		key := fmt.Sprintf("%s:%s", schema, id)
+
+		_, err = m.flights.do(ctx, key, model, func() error {
+			m.log(op, "Source", "DB", "ID", id)
+
+			// The version must be read before the model, so any write that could make
+			// it stale is detected
+			version := m.writes.version(schema)
+
+			start := time.Now()
+			err := db.Get(model, id)
+			if err != nil {
+				m.logError(op, err, "Source", "DB", "ID", id)
+				return err
+			}
+			m.recordFetch(schema, time.Since(start))
+
+			if useCache {
+				// Failing to populate the cache should not fail the read
+				cacheErr := m.writes.set(cache, model, version)
+				m.logError(op, cacheErr, "Source", "Cache", "ID", id)
+			}
+
+			return nil
+		})
+
+		if err != nil && refreshing {
+			m.logError(op, err, "Source", "DB", "ID", id, "Refresh", "Early")
+			err = m.refreshFailed(cache, model, id, err)
+		}
 	}
 
-	// TODO: If found in database and no error add to cache
-
 	if err != nil {
 		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
 	}
@@ -64,52 +137,115 @@ func (m *Manager) Get(model interfaces.Model, id interface{}) error {
 }
 
 // QueryOne receives a model and a query. Will return a single model that
-// satifies the query.
-func (m *Manager) QueryOne(model interfaces.Model, query string) error {
+// satifies the query. If the query cache is enabled results are read from the Cache
+// when available.
+func (m *Manager) QueryOne(model interfaces.Model, q *query.Query) error {
+	return m.QueryOneContext(context.Background(), model, q)
+}
+
+// QueryOneContext is like QueryOne but the Database and Cache operations use the context
+func (m *Manager) QueryOneContext(ctx context.Context, model interfaces.Model, q *query.Query) error {
 	const op = "Manager.QueryOne"
 
-	if m.DB != nil {
-		m.log(op, "Query", query)
+	err := checkContext(op, ctx)
+	if err != nil {
+		return err
+	}
 
-		err := m.DB.QueryOne(model, query)
+	key, ok := m.getCachedQuery(ctx, model, model, q)
+	if ok {
+		return nil
+	}
+
+	db := m.database(ctx)
+	if db != nil {
+		m.log(op, "Query", q)
+
+		err = db.QueryOne(model, q)
 		if err != nil {
-			m.logError(op, err, "Source", "DB", "ID", query)
+			m.logError(op, err, "Source", "DB", "Query", q)
 			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
 		}
+
+		m.setCachedQuery(ctx, model, key, model)
 	}
 
 	return nil
 }
 
 // Query receives a model and a query. Will return all models that satisfies the
-// query.
-func (m *Manager) Query(mList interface{}, model interfaces.Model, query ...string) error {
+// query. If the query cache is enabled results are read from the Cache when available.
+func (m *Manager) Query(mList interface{}, model interfaces.Model, q *query.Query) error {
+	return m.QueryContext(context.Background(), mList, model, q)
+}
+
+// QueryContext is like Query but the Database and Cache operations use the context
+func (m *Manager) QueryContext(ctx context.Context, mList interface{}, model interfaces.Model, q *query.Query) error {
 	const op = "Manager.Query"
 
-	if m.DB != nil {
-		m.log(op, "Query", query)
+	err := checkContext(op, ctx)
+	if err != nil {
+		return err
+	}
 
-		err := m.DB.Query(mList, model, query)
+	key, ok := m.getCachedQuery(ctx, model, mList, q)
+	if ok {
+		return nil
+	}
+
+	db := m.database(ctx)
+	if db != nil {
+		m.log(op, "Query", q)
+
+		err = db.Query(mList, model, q)
 		if err != nil {
-			m.logError(op, err, "Source", "DB", "ID", query)
+			m.logError(op, err, "Source", "DB", "Query", q)
 			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
 		}
+
+		m.setCachedQuery(ctx, model, key, mList)
 	}
 
 	return nil
 }
 
-// RawQuery receives a model and a raw query. Will return all models that satisfies the
-// raw query.
-func (m *Manager) RawQuery(mList interface{}, model interfaces.Model, query ...string) error {
+// QueryOneRaw is like QueryOne but receives the condition as a string in the native
+// format of the Database with its arguments, as QueryOne did before queries were built
+// with the query package. Values should always be passed as arguments using ?
+// placeholders instead of being written in the condition
+func (m *Manager) QueryOneRaw(model interfaces.Model, where string, args ...interface{}) error {
+	return m.QueryOneContext(context.Background(), model, query.Where(query.Raw(where, args...)))
+}
+
+// QueryRaw is like Query but receives the condition as a string in the native format
+// of the Database with its arguments. Unlike RawQuery it only receives the condition,
+// not the whole statement
+func (m *Manager) QueryRaw(mList interface{}, model interfaces.Model, where string, args ...interface{}) error {
+	return m.QueryContext(context.Background(), mList, model, query.Where(query.Raw(where, args...)))
+}
+
+// RawQuery receives a model and a raw query with its arguments. Will return all
+// models that satisfy the raw query.
+func (m *Manager) RawQuery(mList interface{}, model interfaces.Model, rawQuery string, args ...interface{}) error {
+	return m.RawQueryContext(context.Background(), mList, model, rawQuery, args...)
+}
+
+// RawQueryContext is like RawQuery but the Database operations use the context
+func (m *Manager) RawQueryContext(ctx context.Context, mList interface{}, model interfaces.Model, rawQuery string, args ...interface{}) error {
 	const op = "Manager.RawQuery"
 
-	if m.DB != nil {
-		m.log(op, "Query", query)
+	err := checkContext(op, ctx)
+	if err != nil {
+		return err
+	}
 
-		err := m.DB.RawQuery(mList, model, query)
+	db := m.database(ctx)
+	if db != nil {
+		m.log(op, "Query", rawQuery, "Args", args)
+
+		err = db.RawQuery(mList, model, rawQuery, args...)
 		if err != nil {
-			m.logError(op, err, "Source", "DB", "ID", query)
+			m.logError(op, err, "Source", "DB", "Query", rawQuery)
 			return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
 		}
 	}
@@ -117,101 +253,118 @@ func (m *Manager) RawQuery(mList interface{}, model interfaces.Model, query ...s
 	return nil
 }
 
-// Stage setups a model for changes, no change will be applied until State.Commit() is run
-func (m *Manager) Stage(model interfaces.Model, operation string) error {
-	const op = "Manager.Stage"
-
-	m.log(op, "Model", model.GetSchema(), "ID", model.GetID())
-	ch, err := NewChange(model, operation)
+// Begin starts a new UnitOfWork to stage, commit and rollback changes independently
+// from other units of work
+func (m *Manager) Begin() *UnitOfWork {
+	return newUnitOfWork(m)
+}
 
-	if err != nil {
-		m.logError(op, err, "Model", model.GetSchema(), "ID", model.GetID())
-		return ez.New(op, ez.EINVALID, "Failed to stage model for changes", err)
-	}
+// Stage setups a model for changes in the default UnitOfWork, no change will be
+// applied until State.Commit() is run. Models that implement interfaces.Validator are
+// validated before being staged
+func (m *Manager) Stage(model interfaces.Model, operation string) error {
+	return m.work.Stage(model, operation)
+}
 
-	m.stagedChanges = append(m.stagedChanges, ch)
-	return nil
+// StageContext is like Stage but the context is passed to the BeforeStage hooks
+func (m *Manager) StageContext(ctx context.Context, model interfaces.Model, operation string) error {
+	return m.work.StageContext(ctx, model, operation)
 }
 
-// Commit applies all of the staged changes
+// Commit applies all of the changes staged in the default UnitOfWork. If the Database
+// supports transactions the changes are applied atomically, either all of them are
+// persisted or none
 func (m *Manager) Commit() error {
-	const op = "Manager.Commit"
-
-	var err error
-	m.appliedChanges = []*Change{}
+	return m.work.Commit()
+}
 
-	for _, change := range m.stagedChanges {
-		err = change.Apply(m.DB, m.Cache)
-		if change.status == "success" {
-			m.appliedChanges = append(m.appliedChanges, change)
-		}
-	}
+// CommitContext is like Commit but the Database and Cache operations use the context
+func (m *Manager) CommitContext(ctx context.Context) error {
+	return m.work.CommitContext(ctx)
+}
 
-	if err != nil {
-		return ez.New(op, ez.ECONFLICT, "One or more changes could not be commited", err)
-	}
+// Plan predicts the outcome of commiting the changes staged in the default UnitOfWork
+// without writing to the Database or Cache
+func (m *Manager) Plan() (*Plan, error) {
+	return m.work.Plan()
+}
 
-	m.Clear()
-	return nil
+// PlanContext is like Plan but the Database and Cache operations use the context
+func (m *Manager) PlanContext(ctx context.Context) (*Plan, error) {
+	return m.work.PlanContext(ctx)
 }
 
-// Rollback reverts the latest applied changes for the insert operation
+// Rollback reverts the latest changes applied by the default UnitOfWork in reverse order
 func (m *Manager) Rollback() error {
-	const op = "Manager.Rollback"
-
-	var err error
-	rollbackChanges := m.appliedChanges
-	m.appliedChanges = []*Change{}
-
-	for _, change := range rollbackChanges {
-		err = change.Revert(m.DB, m.Cache)
-		if change.status != "reverted" {
-			m.appliedChanges = append(m.appliedChanges, change)
-		}
-	}
-
-	if err != nil {
-		return ez.New(op, ez.ECONFLICT, "Could not rollback one or more changes", nil)
-	}
+	return m.work.Rollback()
+}
 
-	return nil
+// RollbackContext is like Rollback but the Database and Cache operations use the context
+func (m *Manager) RollbackContext(ctx context.Context) error {
+	return m.work.RollbackContext(ctx)
 }
 
-// Clear deletes the list of staged chanbes
+// Clear deletes the list of changes staged in the default UnitOfWork
 func (m *Manager) Clear() {
-	m.stagedChanges = []*Change{}
+	m.work.Clear()
 }
 
-// Status returns the current list of staged changes
+// Status returns the current list of changes staged in the default UnitOfWork
 func (m *Manager) Status() []*Change {
-	return m.stagedChanges
+	return m.work.Status()
 }
 
-// Applied returns the previous list of applied changes
+// Applied returns the previous list of changes applied by the default UnitOfWork
 func (m *Manager) Applied() []*Change {
-	return m.appliedChanges
+	return m.work.Applied()
 }
 
-// PrintStatus display the current status of staged changes
+// PrintStatus display the current status of changes staged in the default UnitOfWork
 func (m *Manager) PrintStatus() {
-	for _, change := range m.stagedChanges {
-		fmt.Println("Model:", change.model, "OP:", change.op, "Status:", change.status, "Error:", change.err)
-	}
+	m.work.PrintStatus()
+}
+
+// ToggleCoalescing enables or disables merging the changes staged for the same model,
+// it is disabled by default
+func (m *Manager) ToggleCoalescing() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.coalesce = !m.coalesce
+}
+
+// coalescingEnabled returns if changes staged for the same model should be merged
+func (m *Manager) coalescingEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.coalesce
 }
 
 // ToggleLogs enables or disables detailed logs
 func (m *Manager) ToggleLogs() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.logging = !m.logging
 }
 
+// loggingEnabled returns if detailed logs are enabled
+func (m *Manager) loggingEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.logging
+}
+
 func (m *Manager) log(op string, ctx ...interface{}) {
-	if m.logging {
+	if m.loggingEnabled() {
 		log.Info(op, ctx...)
 	}
 }
 
 func (m *Manager) logError(op string, err error, ctx ...interface{}) {
-	if m.logging && err != nil {
+	if err != nil && m.loggingEnabled() {
 		ctx = append(ctx, "Error", ez.ErrorMessage(err))
 		log.Info(op, ctx...)
 	}
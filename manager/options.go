@@ -0,0 +1,33 @@
+package manager
+
+// GetOption modifies how Manager.Get uses the Cache
+type GetOption func(*getOptions)
+
+type getOptions struct {
+	skipCache    bool
+	refreshCache bool
+}
+
+// SkipCache makes Get read the model directly from the Database without reading or
+// updating the Cache
+func SkipCache() GetOption {
+	return func(o *getOptions) {
+		o.skipCache = true
+	}
+}
+
+// RefreshCache makes Get read the model from the Database and replace the copy
+// stored in the Cache
+func RefreshCache() GetOption {
+	return func(o *getOptions) {
+		o.refreshCache = true
+	}
+}
+
+func newGetOptions(opts []GetOption) *getOptions {
+	o := &getOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// ToggleOutbox enables or disables storing the events of commits and rollbacks in the
+// outbox of the Database, in the same transaction as the changes. The Database must
+// implement interfaces.TxDatabase and interfaces.OutboxDatabase
+func (m *Manager) ToggleOutbox() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outbox = !m.outbox
+}
+
+// outboxEnabled returns if events should be stored in the outbox
+func (m *Manager) outboxEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.outbox
+}
+
+// checkOutbox returns an error if the outbox is enabled but the Database can not
+// store events along with the changes
+func (m *Manager) checkOutbox(op string, db interfaces.Database) error {
+	if !m.outboxEnabled() {
+		return nil
+	}
+
+	_, isTx := db.(interfaces.TxDatabase)
+	_, isOutbox := db.(interfaces.OutboxDatabase)
+	if !isTx || !isOutbox {
+		return ez.New(op, ez.EINVALID, "The outbox requires a Database that supports transactions and an outbox", nil)
+	}
+
+	return nil
+}
+
+// writeOutbox stores the events of the changes in the outbox of the transaction
+func (m *Manager) writeOutbox(tx interfaces.TxDatabase, changes []*Change, event func(*Change, time.Time) interfaces.Event) error {
+	const op = "Manager.writeOutbox"
+
+	if !m.outboxEnabled() || len(changes) == 0 {
+		return nil
+	}
+
+	outbox, ok := tx.(interfaces.OutboxDatabase)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "The transaction does not support an outbox", nil)
+	}
+
+	now := time.Now()
+	events := make([]interfaces.Event, len(changes))
+	for i, change := range changes {
+		events[i] = event(change, now)
+	}
+
+	return outbox.WriteOutbox(events)
+}
@@ -0,0 +1,175 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// Plan outcomes
+const (
+	APPLY = "apply"
+	SKIP  = "skip"
+	FAIL  = "fail"
+)
+
+// Plan defines the predicted outcome of commiting the staged changes
+type Plan struct {
+	Steps []PlanStep
+}
+
+// PlanStep defines the predicted outcome of a staged change
+type PlanStep struct {
+	Change  *Change
+	Outcome string
+	Err     error
+}
+
+// OK returns true if no change is predicted to fail
+func (p *Plan) OK() bool {
+	for _, step := range p.Steps {
+		if step.Outcome == FAIL {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Failed returns the steps of the changes that are predicted to fail
+func (p *Plan) Failed() []PlanStep {
+	failed := []PlanStep{}
+	for _, step := range p.Steps {
+		if step.Outcome == FAIL {
+			failed = append(failed, step)
+		}
+	}
+
+	return failed
+}
+
+// Plan predicts the outcome of commiting the staged changes without writing to the
+// Database or Cache
+func (u *UnitOfWork) Plan() (*Plan, error) {
+	return u.PlanContext(context.Background())
+}
+
+// PlanContext is like Plan but the Database and Cache operations use the context. The
+// changes are validated, their before hooks and the BeforeStage and BeforeCommit hooks
+// of the Manager are run on copies of the changes and the existence of the models is
+// checked, taking into account the previous changes. A BeforeCommit veto is predicted
+// as a failure of every change that would be applied
+func (u *UnitOfWork) PlanContext(ctx context.Context) (*Plan, error) {
+	const op = "UnitOfWork.Plan"
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	db := u.manager.database(ctx)
+	cache := u.manager.cache(ctx)
+
+	// Existence of the models after the previous changes of the plan
+	exists := map[string]bool{}
+
+	// Hooks are run on copies to avoid modifying the staged changes
+	dryRuns := make([]*Change, 0, len(u.stagedChanges))
+
+	plan := &Plan{Steps: make([]PlanStep, 0, len(u.stagedChanges))}
+	for _, change := range u.stagedChanges {
+		err := checkContext(op, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		step := PlanStep{Change: change, Outcome: APPLY}
+		dryRun := change.dryRun()
+		dryRuns = append(dryRuns, dryRun)
+
+		if change.status == SUCCESS || change.status == REVERTED {
+			step.Outcome = SKIP
+			plan.Steps = append(plan.Steps, step)
+			continue
+		}
+
+		key := change.model.GetSchema().Name + ":" + change.model.GetID()
+
+		found, ok := exists[key]
+		if !ok {
+			found, err = modelExists(db, cache, change.model)
+		}
+
+		if err == nil {
+			err = planChange(dryRun, found)
+		}
+
+		if err == nil {
+			err = u.manager.beforeStage(ctx, dryRun)
+		}
+
+		if err != nil {
+			step.Outcome = FAIL
+			step.Err = err
+		} else {
+			exists[key] = change.op != DELETE
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	err := u.manager.beforeCommit(ctx, dryRuns)
+	if err != nil {
+		for i := range plan.Steps {
+			if plan.Steps[i].Outcome == APPLY {
+				plan.Steps[i].Outcome = FAIL
+				plan.Steps[i].Err = err
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// planChange returns the error the change is predicted to fail with, the change must
+// be a copy as its before hook is run
+func planChange(change *Change, exists bool) error {
+	const op = "Changes.Plan"
+
+	err := change.validate()
+	if err != nil {
+		return ez.New(op, ez.EINVALID, "Model is not valid", err)
+	}
+
+	switch {
+	case change.op == INSERT && exists:
+		msg := fmt.Sprintf("Can not insert %s into %s, it already exists", change.model.GetID(), change.model.GetSchema().Name)
+		return ez.New(op, ez.ECONFLICT, msg, nil)
+	case change.op != INSERT && !exists:
+		msg := fmt.Sprintf("Can not %s %s from %s, it does not exist", change.op, change.model.GetID(), change.model.GetSchema().Name)
+		return ez.New(op, ez.ENOTFOUND, msg, nil)
+	}
+
+	return change.beforeApply()
+}
+
+// modelExists checks if the model is stored in the Database, or in the Cache if there
+// is no Database
+func modelExists(db interfaces.Database, cache interfaces.Cache, m interfaces.Model) (bool, error) {
+	var err error
+
+	if db != nil {
+		err = db.Get(newModel(m), m.GetID())
+	} else {
+		err = cache.Get(newModel(m), m.GetID())
+	}
+
+	switch {
+	case err == nil:
+		return true, nil
+	case ez.ErrorCode(err) == ez.ENOTFOUND:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,221 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/query"
+)
+
+// queryResult defines the results of a query stored in the Cache
+type queryResult struct {
+	Schema string          `json:"schema"`
+	Key    string          `json:"key"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// GetSchema returns the schema used to store query results in the Cache
+func (r *queryResult) GetSchema() *interfaces.Schema {
+	return &interfaces.Schema{Name: "query_results", PKey: "query:" + r.Schema}
+}
+
+// GetID returns the key of the query results
+func (r *queryResult) GetID() string {
+	return r.Key
+}
+
+// Update replaces the query results with the provided ones
+func (r *queryResult) Update(i interface{}) error {
+	const op = "queryResult.Update"
+
+	result, ok := i.(*queryResult)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not of type queryResult", nil)
+	}
+
+	*r = *result
+	return nil
+}
+
+// queryGeneration defines the current generation of the cached query results of a
+// schema, results from previous generations are no longer valid
+type queryGeneration struct {
+	Schema     string `json:"schema"`
+	Generation string `json:"generation"`
+}
+
+// GetSchema returns the schema used to store query generations in the Cache
+func (g *queryGeneration) GetSchema() *interfaces.Schema {
+	return &interfaces.Schema{Name: "query_generations", PKey: "query_generation"}
+}
+
+// GetID returns the schema of the query generation
+func (g *queryGeneration) GetID() string {
+	return g.Schema
+}
+
+// Update replaces the query generation with the provided one
+func (g *queryGeneration) Update(i interface{}) error {
+	const op = "queryGeneration.Update"
+
+	generation, ok := i.(*queryGeneration)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not of type queryGeneration", nil)
+	}
+
+	*g = *generation
+	return nil
+}
+
+// QueryGeneration returns the Cache entry that points to the cached query results of a
+// schema. Removing it from a Cache invalidates the results cached there, which lets an
+// instance discard the results of a schema changed by another one
+func QueryGeneration(schema string) interfaces.Model {
+	return &queryGeneration{Schema: schema}
+}
+
+// ToggleQueryCache enables or disables caching the results of QueryOne and Query. Cached
+// results of a schema are invalidated when changes to that schema are commited
+func (m *Manager) ToggleQueryCache() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queryCache = !m.queryCache
+}
+
+// queryCacheEnabled returns if the results of queries should be cached
+func (m *Manager) queryCacheEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.queryCache && m.Cache != nil
+}
+
+// getCachedQuery attempts to load the cached results of a query into dest
+func (m *Manager) getCachedQuery(ctx context.Context, model interfaces.Model, dest interface{}, q *query.Query) (string, bool) {
+	const op = "Manager.getCachedQuery"
+
+	if !m.queryCacheEnabled() {
+		return "", false
+	}
+
+	cache := m.cache(ctx)
+	schema := model.GetSchema().Name
+
+	generation, err := currentQueryGeneration(cache, schema)
+	if err != nil {
+		m.logError(op, err, "Schema", schema)
+		return "", false
+	}
+
+	key := queryKey(generation, dest, q)
+	result := &queryResult{Schema: schema}
+
+	err = cache.Get(result, key)
+	if err != nil {
+		return key, false
+	}
+
+	err = json.Unmarshal(result.Data, dest)
+	if err != nil {
+		m.logError(op, err, "Schema", schema, "Query", q)
+		return key, false
+	}
+
+	m.log(op, "Source", "Cache", "Query", q)
+	return key, true
+}
+
+// setCachedQuery stores the results of a query in the Cache
+func (m *Manager) setCachedQuery(ctx context.Context, model interfaces.Model, key string, results interface{}) {
+	const op = "Manager.setCachedQuery"
+
+	if !m.queryCacheEnabled() || key == "" {
+		return
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		m.logError(op, err, "Schema", model.GetSchema().Name)
+		return
+	}
+
+	result := &queryResult{Schema: model.GetSchema().Name, Key: key, Data: data}
+
+	cache := m.cache(ctx)
+	err = cache.Set(result, cache.GetTTL())
+	m.logError(op, err, "Schema", model.GetSchema().Name)
+}
+
+// invalidateQueries discards the cached query results of the schemas modified by
+// the changes
+func (m *Manager) invalidateQueries(changes []*Change) {
+	const op = "Manager.invalidateQueries"
+
+	if !m.queryCacheEnabled() {
+		return
+	}
+
+	invalidated := map[string]bool{}
+	for _, change := range changes {
+		schema := change.model.GetSchema().Name
+		if invalidated[schema] {
+			continue
+		}
+
+		_, err := newQueryGeneration(m.Cache, schema)
+		m.logError(op, err, "Schema", schema)
+		invalidated[schema] = true
+	}
+}
+
+// currentQueryGeneration returns the current generation of the cached query results
+// of a schema, creating a new one if there is none
+func currentQueryGeneration(cache interfaces.Cache, schema string) (string, error) {
+	generation := &queryGeneration{Schema: schema}
+
+	err := cache.Get(generation, schema)
+	if err == nil {
+		return generation.Generation, nil
+	}
+
+	// A missing generation could have been evicted, so a new one is created to avoid
+	// reading results that were cached before it
+	return newQueryGeneration(cache, schema)
+}
+
+// newQueryGeneration replaces the generation of the cached query results of a schema
+func newQueryGeneration(cache interfaces.Cache, schema string) (string, error) {
+	generation := &queryGeneration{
+		Schema:     schema,
+		Generation: strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+
+	// Generations never expire, the results they point to do
+	err := cache.Set(generation, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return generation.Generation, nil
+}
+
+// queryKey returns the key used to cache the results of a query, it depends on the
+// query, the type of the results and the generation of the schema
+func queryKey(generation string, dest interface{}, q *query.Query) string {
+	normalized := ""
+	if q != nil {
+		normalized = q.String()
+	}
+
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s", generation, reflect.TypeOf(dest), normalized)))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// fetchWeight is the weight of the latest Database read in the average read time of
+// a schema
+const fetchWeight = 0.2
+
+// SetEarlyRefresh enables refreshing cached models from the Database before they
+// expire, so a popular model is reloaded by a single read instead of all of them
+// missing the Cache at once. A read refreshes the model early with a probability that
+// grows as the expiry gets closer and the longer the Database takes to load it, beta
+// scales that probability, 1 is a good default and 0 disables it. Requires a Cache
+// that implements interfaces.ExpiryCache
+func (m *Manager) SetEarlyRefresh(beta float64) error {
+	const op = "Manager.SetEarlyRefresh"
+
+	if beta < 0 {
+		return ez.New(op, ez.EINVALID, "The early refresh beta can not be negative", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshBeta = beta
+	return nil
+}
+
+// earlyRefreshBeta returns the beta used to refresh models early, 0 if it is disabled
+func (m *Manager) earlyRefreshBeta() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.refreshBeta
+}
+
+// recordFetch updates the average time the Database takes to read a model of the schema
+func (m *Manager) recordFetch(schema string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.fetchTimes == nil {
+		m.fetchTimes = map[string]time.Duration{}
+	}
+
+	avg, ok := m.fetchTimes[schema]
+	if !ok {
+		m.fetchTimes[schema] = d
+		return
+	}
+
+	m.fetchTimes[schema] = time.Duration(float64(avg)*(1-fetchWeight) + float64(d)*fetchWeight)
+}
+
+// fetchTime returns the average time the Database takes to read a model of the schema
+func (m *Manager) fetchTime(schema string) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.fetchTimes[schema]
+}
+
+// shouldRefresh decides if a cached model should be refreshed before it expires using
+// probabilistic early expiration (XFetch)
+func (m *Manager) shouldRefresh(cache interfaces.Cache, model interfaces.Model, id interface{}) bool {
+	beta := m.earlyRefreshBeta()
+	if beta == 0 {
+		return false
+	}
+
+	expiring, ok := cache.(interfaces.ExpiryCache)
+	if !ok {
+		return false
+	}
+
+	delta := m.fetchTime(model.GetSchema().Name)
+	if delta == 0 {
+		return false
+	}
+
+	expiry, err := expiring.Expiry(model, id)
+	if err != nil || expiry.IsZero() {
+		return false
+	}
+
+	// -log(u) with u in (0, 1] is an exponential sample, so most reads only refresh
+	// when the expiry is a few read times away
+	gap := time.Duration(float64(delta) * beta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(gap).Before(expiry)
+}
+
+// refreshFailed handles an early refresh that could not read the model from the
+// Database. If the model no longer exists it is removed from the Cache and the error is
+// returned, otherwise the cached copy is still valid so it is read again and served
+func (m *Manager) refreshFailed(cache interfaces.Cache, model interfaces.Model, id interface{}, err error) error {
+	const op = "Manager.refreshFailed"
+
+	if ez.ErrorCode(err) == ez.ENOTFOUND {
+		cacheErr := cache.Delete(model)
+		m.logError(op, cacheErr, "Source", "Cache", "ID", id)
+		return err
+	}
+
+	// The failed read could have modified the model
+	cacheErr := cache.Get(model, id)
+	if cacheErr != nil {
+		m.logError(op, cacheErr, "Source", "Cache", "ID", id)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/vanclief/state/interfaces"
+)
+
+// flight defines a Database read in progress that other reads of the same model wait for
+type flight struct {
+	done     chan struct{}
+	waiters  int
+	data     []byte
+	err      error
+	canceled bool
+	panicked interface{}
+}
+
+// flightGroup collapses concurrent reads of the same model into a single Database read
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do runs fn to load the model unless a read with the same key is in progress, in which
+// case it waits for it and decodes a copy of its result into the model. Like the
+// caches, the copy is made through JSON so fields that are not encoded are left empty.
+// If the read fails because the context of the caller that started it is done, the
+// waiters read again instead of failing with it, and if fn panics the waiters panic
+// too. Waiters stop waiting when their own context is done. Returns if the result was
+// shared with another read
+func (g *flightGroup) do(ctx context.Context, key string, model interfaces.Model, fn func() error) (bool, error) {
+	const op = "Manager.flightGroup.do"
+
+	for {
+		g.mu.Lock()
+		if g.flights == nil {
+			g.flights = map[string]*flight{}
+		}
+
+		f, ok := g.flights[key]
+		if !ok {
+			f = &flight{done: make(chan struct{})}
+			g.flights[key] = f
+			g.mu.Unlock()
+
+			return false, g.lead(ctx, key, f, model, fn)
+		}
+
+		f.waiters++
+		g.mu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			g.mu.Lock()
+			f.waiters--
+			g.mu.Unlock()
+
+			return true, checkContext(op, ctx)
+		}
+
+		if f.panicked != nil {
+			panic(f.panicked)
+		}
+
+		if f.canceled {
+			continue
+		}
+
+		if f.err != nil {
+			return true, f.err
+		}
+
+		// Each waiter gets its own copy, so callers never share a model
+		return true, json.Unmarshal(f.data, model)
+	}
+}
+
+// lead runs fn for the flight and shares its result with the waiters. The flight is
+// always finished, even if fn panics, so the waiters are never left blocked
+func (g *flightGroup) lead(ctx context.Context, key string, f *flight, model interfaces.Model, fn func() error) (err error) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			f.panicked = r
+		}
+
+		// Once the flight is removed no more waiters can join, so the result is only
+		// encoded when someone is waiting for it
+		g.mu.Lock()
+		delete(g.flights, key)
+		waiters := f.waiters
+		g.mu.Unlock()
+
+		f.err = err
+		f.canceled = err != nil && ctx.Err() != nil
+		if r == nil && err == nil && waiters > 0 {
+			f.data, f.err = json.Marshal(model)
+		}
+		close(f.done)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	return fn()
+}
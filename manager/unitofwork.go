@@ -0,0 +1,545 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// UnitOfWork defines a set of changes that are commited or rolled back together. Each
+// request should use its own UnitOfWork, while the Manager that created it is shared
+type UnitOfWork struct {
+	manager        *Manager
+	mu             sync.RWMutex
+	stagedChanges  []*Change
+	appliedChanges []*Change
+	events         []interfaces.Event
+}
+
+// newUnitOfWork creates an empty UnitOfWork that uses the storage of the Manager
+func newUnitOfWork(m *Manager) *UnitOfWork {
+	return &UnitOfWork{manager: m}
+}
+
+// Stage setups a model for changes, no change will be applied until Commit() is run.
+// Models that implement interfaces.Validator are validated before being staged
+func (u *UnitOfWork) Stage(model interfaces.Model, operation string) error {
+	return u.StageContext(context.Background(), model, operation)
+}
+
+// StageContext is like Stage but the context is passed to the BeforeStage hooks
+func (u *UnitOfWork) StageContext(ctx context.Context, model interfaces.Model, operation string) error {
+	const op = "UnitOfWork.Stage"
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.manager.log(op, "Model", model.GetSchema(), "ID", model.GetID())
+	ch, err := NewChange(model, operation)
+
+	if err != nil {
+		u.manager.logError(op, err, "Model", model.GetSchema(), "ID", model.GetID())
+		return ez.New(op, ez.EINVALID, "Failed to stage model for changes", err)
+	}
+
+	err = ch.validate()
+	if err != nil {
+		u.manager.logError(op, err, "Model", model.GetSchema(), "ID", model.GetID())
+		return ez.New(op, ez.EINVALID, "Model is not valid", err)
+	}
+
+	err = u.manager.beforeStage(ctx, ch)
+	if err != nil {
+		u.manager.logError(op, err, "Model", model.GetSchema(), "ID", model.GetID())
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if u.manager.coalescingEnabled() && u.coalesce(ch) {
+		return nil
+	}
+
+	u.stagedChanges = append(u.stagedChanges, ch)
+	return nil
+}
+
+// coalesce merges the change with the last pending change of the same model, returns
+// false if they can not be merged. An insert followed by an update becomes an insert
+// of the latest state, an insert followed by a delete cancels out, repeated updates
+// collapse into the latest one and an update followed by a delete becomes the delete,
+// which keeps its later position. The merged change keeps the annotations of both,
+// with the ones of the latest change taking precedence. The caller must hold the lock
+func (u *UnitOfWork) coalesce(ch *Change) bool {
+	schema := ch.model.GetSchema().Name
+	id := ch.model.GetID()
+
+	for i := len(u.stagedChanges) - 1; i >= 0; i-- {
+		prev := u.stagedChanges[i]
+		if prev.model.GetSchema().Name != schema || prev.model.GetID() != id {
+			continue
+		}
+
+		if prev.status != PENDING {
+			return false
+		}
+
+		switch {
+		case prev.op == INSERT && ch.op == UPDATE:
+			ch.op = INSERT
+			u.stagedChanges[i] = ch
+		case prev.op == INSERT && ch.op == DELETE:
+			u.stagedChanges = append(u.stagedChanges[:i], u.stagedChanges[i+1:]...)
+			return true
+		case prev.op == UPDATE && ch.op == UPDATE:
+			u.stagedChanges[i] = ch
+		case prev.op == UPDATE && ch.op == DELETE:
+			u.stagedChanges = append(u.stagedChanges[:i], u.stagedChanges[i+1:]...)
+			u.stagedChanges = append(u.stagedChanges, ch)
+		default:
+			return false
+		}
+
+		ch.inheritAnnotations(prev)
+		return true
+	}
+
+	return false
+}
+
+// Commit applies all of the staged changes. If the Database supports transactions
+// the changes are applied atomically, either all of them are persisted or none
+func (u *UnitOfWork) Commit() error {
+	return u.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit but the Database operations use the context. If the
+// context is canceled no further changes are applied, and with transactions none of
+// them is persisted. The Cache is updated even if the context is canceled after the
+// changes are persisted, so it stays consistent with the Database
+func (u *UnitOfWork) CommitContext(ctx context.Context) error {
+	u.mu.Lock()
+	done := u.manager.writes.begin(u.stagedChanges)
+	err := u.commit(ctx)
+	done()
+	applied := append([]*Change{}, u.appliedChanges...)
+	events := u.takeEvents()
+	u.mu.Unlock()
+
+	u.manager.afterCommit(ctx, applied, err)
+	u.manager.audit(ctx, events)
+	u.manager.publish(ctx, events)
+	return err
+}
+
+// commit applies the staged changes, the caller must hold the lock
+func (u *UnitOfWork) commit(ctx context.Context) error {
+	const op = "UnitOfWork.Commit"
+
+	err := checkContext(op, ctx)
+	if err != nil {
+		return err
+	}
+
+	err = u.manager.beforeCommit(ctx, append([]*Change{}, u.stagedChanges...))
+	if err != nil {
+		u.manager.logError(op, err)
+		return ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	u.appliedChanges = []*Change{}
+	db := u.manager.database(ctx)
+
+	err = u.manager.checkOutbox(op, db)
+	if err != nil {
+		return err
+	}
+
+	txdb, ok := db.(interfaces.TxDatabase)
+	if !ok {
+		for _, change := range u.stagedChanges {
+			ctxErr := checkContext(op, ctx)
+			if ctxErr != nil {
+				err = ctxErr
+				break
+			}
+
+			applied := change.status == SUCCESS
+
+			applyErr := change.Apply(db, u.manager.Cache)
+			if applyErr != nil {
+				err = applyErr
+			}
+			if change.status == SUCCESS {
+				u.appliedChanges = append(u.appliedChanges, change)
+				if !applied {
+					u.manager.afterApply(ctx, change)
+					u.record(commitEvent, change)
+				}
+			}
+		}
+
+		u.manager.invalidateQueries(u.appliedChanges)
+
+		if err != nil {
+			return ez.New(op, ez.ECONFLICT, "One or more changes could not be commited", err)
+		}
+
+		u.clear()
+		return nil
+	}
+
+	err = u.commitTx(ctx, txdb)
+	if err != nil {
+		return ez.New(op, ez.ECONFLICT, "One or more changes could not be commited", err)
+	}
+
+	// The changes are persisted, so from here on a cache failure does not undo them
+	if batchCache, ok := u.manager.Cache.(interfaces.BatchCache); ok {
+		// Caches that support batches are updated with a single round trip per run of
+		// changes
+		err = applyCacheBatch(batchCache, u.stagedChanges)
+		u.manager.logError(op, err)
+	} else if u.manager.Cache != nil {
+		for _, change := range u.stagedChanges {
+			cacheErr := change.applyCache(u.manager.Cache)
+			if cacheErr != nil {
+				u.manager.logError(op, cacheErr, "Model", change.model.GetSchema(), "ID", change.model.GetID())
+				change.cacheFailed(u.manager.Cache, SUCCESS, cacheErr)
+				err = cacheErr
+			}
+		}
+	}
+
+	for _, change := range u.stagedChanges {
+		if change.status == SUCCESS {
+			u.appliedChanges = append(u.appliedChanges, change)
+		}
+	}
+
+	u.manager.invalidateQueries(u.appliedChanges)
+	u.clear()
+
+	if err != nil {
+		return ez.New(op, ez.ECONFLICT, "The changes were commited but one or more could not be applied to the cache", err)
+	}
+
+	return nil
+}
+
+// commitTx applies the staged changes to the database inside a single transaction,
+// if any of them fails the transaction is rolled back and no change is persisted
+func (u *UnitOfWork) commitTx(ctx context.Context, txdb interfaces.TxDatabase) error {
+	txChanges, failedBatch, err := u.applyTx(ctx, txdb, true)
+	if failedBatch != nil {
+		// A batch fails as a whole, so its changes are applied one by one in a new
+		// transaction to find out which of them caused the failure
+		for _, change := range failedBatch {
+			change.status = PENDING
+			change.err = nil
+		}
+		txChanges, _, err = u.applyTx(ctx, txdb, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, change := range txChanges {
+		change.afterApply()
+		u.manager.afterApply(ctx, change)
+		u.record(commitEvent, change)
+	}
+
+	return nil
+}
+
+// applyTx applies the pending changes inside a transaction and returns the ones that
+// were persisted. If batching is set, databases that support batches apply each run of
+// changes with the same schema and operation with a single statement, and the batch
+// that fails is returned
+func (u *UnitOfWork) applyTx(ctx context.Context, txdb interfaces.TxDatabase, batching bool) ([]*Change, []*Change, error) {
+	const op = "UnitOfWork.commitTx"
+
+	tx, err := txdb.Begin()
+	if err != nil {
+		u.manager.logError(op, err)
+		return nil, nil, err
+	}
+
+	var pending []*Change
+	for _, change := range u.stagedChanges {
+		// Ignore changes that have been successfuly applied or reverted
+		if change.status != SUCCESS && change.status != REVERTED {
+			pending = append(pending, change)
+		}
+	}
+
+	batchdb, ok := tx.(interfaces.BatchDatabase)
+	batching = batching && ok
+
+	var txChanges, failedBatch []*Change
+	for len(pending) > 0 {
+		batch := pending[:1]
+		if batching {
+			batch = nextBatch(pending)
+		}
+
+		err = checkContext(op, ctx)
+		if err != nil {
+			break
+		}
+
+		for _, change := range batch {
+			err = change.beforeApply()
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+
+		if len(batch) == 1 {
+			err = batch[0].applyDB(tx)
+		} else {
+			err = applyBatch(batchdb, batch)
+			if err != nil {
+				failedBatch = batch
+			}
+		}
+		if err != nil {
+			break
+		}
+
+		txChanges = append(txChanges, batch...)
+		pending = pending[len(batch):]
+	}
+
+	if err == nil {
+		err = u.manager.writeOutbox(tx, txChanges, commitEvent)
+	}
+
+	if err == nil {
+		err = u.manager.writeAudit(ctx, tx, txChanges, commitEvent)
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else if rbErr := tx.Rollback(); rbErr != nil {
+		u.manager.logError(op, rbErr)
+	}
+
+	if err != nil {
+		u.manager.logError(op, err)
+		// Nothing was persisted, so the changes that succeeded inside the transaction
+		// are pending again
+		for _, change := range txChanges {
+			change.status = PENDING
+		}
+		return nil, failedBatch, err
+	}
+
+	return txChanges, nil, nil
+}
+
+// Rollback reverts the latest applied changes in reverse order. Inserts are deleted,
+// while updated and deleted models are restored to their previous state
+func (u *UnitOfWork) Rollback() error {
+	return u.RollbackContext(context.Background())
+}
+
+// RollbackContext is like Rollback but the Database operations use the context. If
+// the context is canceled no further changes are reverted, and with transactions none
+// of them is
+func (u *UnitOfWork) RollbackContext(ctx context.Context) error {
+	u.mu.Lock()
+	done := u.manager.writes.begin(u.appliedChanges)
+	reverted, err := u.rollback(ctx)
+	done()
+	for _, change := range reverted {
+		u.record(rollbackEvent, change)
+	}
+	events := u.takeEvents()
+	u.mu.Unlock()
+
+	u.manager.onRollback(ctx, reverted, err)
+	u.manager.audit(ctx, events)
+	u.manager.publish(ctx, events)
+	return err
+}
+
+// rollback reverts the applied changes and returns the ones that were reverted, the
+// caller must hold the lock
+func (u *UnitOfWork) rollback(ctx context.Context) ([]*Change, error) {
+	const op = "UnitOfWork.Rollback"
+
+	err := checkContext(op, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := u.manager.database(ctx)
+
+	txdb, ok := db.(interfaces.TxDatabase)
+	if ok {
+		err = u.rollbackTx(ctx, txdb)
+		if err != nil {
+			return nil, ez.New(op, ez.ECONFLICT, "Could not rollback one or more changes", err)
+		}
+	}
+
+	rollbackChanges := u.appliedChanges
+	u.appliedChanges = []*Change{}
+
+	for i := len(rollbackChanges) - 1; i >= 0; i-- {
+		change := rollbackChanges[i]
+
+		var revertErr error
+		if ok {
+			// The database was already reverted inside the transaction, so the change
+			// is reverted even if the cache fails
+			if u.manager.Cache != nil {
+				revertErr = change.revertCache(u.manager.Cache)
+				if revertErr != nil {
+					change.cacheFailed(u.manager.Cache, REVERTED, revertErr)
+				}
+			}
+		} else if revertErr = checkContext(op, ctx); revertErr == nil {
+			revertErr = change.Revert(db, u.manager.Cache)
+		}
+
+		if revertErr != nil {
+			u.manager.logError(op, revertErr, "Model", change.model.GetSchema(), "ID", change.model.GetID())
+			err = revertErr
+		}
+	}
+
+	reverted := []*Change{}
+	for _, change := range rollbackChanges {
+		if change.status != REVERTED {
+			u.appliedChanges = append(u.appliedChanges, change)
+		} else {
+			reverted = append(reverted, change)
+		}
+	}
+
+	u.manager.invalidateQueries(reverted)
+
+	if err != nil {
+		return reverted, ez.New(op, ez.ECONFLICT, "Could not rollback one or more changes", err)
+	}
+
+	return reverted, nil
+}
+
+// rollbackTx reverts the applied changes from the database inside a single
+// transaction, if any of them fails no change is reverted
+func (u *UnitOfWork) rollbackTx(ctx context.Context, txdb interfaces.TxDatabase) error {
+	const op = "UnitOfWork.rollbackTx"
+
+	tx, err := txdb.Begin()
+	if err != nil {
+		u.manager.logError(op, err)
+		return err
+	}
+
+	var txChanges []*Change
+	for i := len(u.appliedChanges) - 1; i >= 0; i-- {
+		change := u.appliedChanges[i]
+		if change.status != SUCCESS {
+			continue
+		}
+
+		err = checkContext(op, ctx)
+		if err != nil {
+			break
+		}
+
+		err = change.revertDB(tx)
+		if err != nil {
+			break
+		}
+		txChanges = append(txChanges, change)
+	}
+
+	if err == nil {
+		err = u.manager.writeOutbox(tx, txChanges, rollbackEvent)
+	}
+
+	if err == nil {
+		err = u.manager.writeAudit(ctx, tx, txChanges, rollbackEvent)
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else if rbErr := tx.Rollback(); rbErr != nil {
+		u.manager.logError(op, rbErr)
+	}
+
+	if err != nil {
+		u.manager.logError(op, err)
+		// Nothing was reverted, so the changes are still applied
+		for _, change := range txChanges {
+			change.status = SUCCESS
+		}
+		return err
+	}
+
+	return nil
+}
+
+// record adds the event of a change to the events that are published and audited once
+// the commit or rollback finishes, the caller must hold the lock
+func (u *UnitOfWork) record(event func(*Change, time.Time) interfaces.Event, change *Change) {
+	if u.manager.hasListeners() || u.manager.getAuditSink() != nil {
+		u.events = append(u.events, event(change, time.Now()))
+	}
+}
+
+// takeEvents returns the recorded events and resets them, the caller must hold the lock
+func (u *UnitOfWork) takeEvents() []interfaces.Event {
+	events := u.events
+	u.events = nil
+	return events
+}
+
+// Clear deletes the list of staged changes
+func (u *UnitOfWork) Clear() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.clear()
+}
+
+// clear deletes the list of staged changes, the caller must hold the lock
+func (u *UnitOfWork) clear() {
+	u.stagedChanges = []*Change{}
+}
+
+// Status returns the current list of staged changes
+func (u *UnitOfWork) Status() []*Change {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return append([]*Change{}, u.stagedChanges...)
+}
+
+// Applied returns the previous list of applied changes
+func (u *UnitOfWork) Applied() []*Change {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return append([]*Change{}, u.appliedChanges...)
+}
+
+// PrintStatus display the current status of staged changes
+func (u *UnitOfWork) PrintStatus() {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, change := range u.stagedChanges {
+		fmt.Println("Model:", change.model, "OP:", change.op, "Status:", change.status, "Error:", change.err)
+	}
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"sync"
+
+	"github.com/vanclief/state/interfaces"
+)
+
+// schemaWrites defines the commits and rollbacks writing models of a schema
+type schemaWrites struct {
+	version uint64
+	pending int
+}
+
+// writeGuard tracks the commits and rollbacks of the Manager per schema, so a read that
+// loaded a model from the Database does not overwrite the Cache with a copy older than
+// the one written by a concurrent commit. Writes of other instances are not tracked
+type writeGuard struct {
+	mu      sync.Mutex
+	schemas map[string]*schemaWrites
+}
+
+// begin marks that the changes are being written and returns the function that marks
+// them as done, which must be called once the Cache is updated
+func (g *writeGuard) begin(changes []*Change) func() {
+	schemas := map[string]bool{}
+	for _, change := range changes {
+		schemas[change.model.GetSchema().Name] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.schemas == nil {
+		g.schemas = map[string]*schemaWrites{}
+	}
+
+	for schema := range schemas {
+		w, ok := g.schemas[schema]
+		if !ok {
+			w = &schemaWrites{}
+			g.schemas[schema] = w
+		}
+		w.version++
+		w.pending++
+	}
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		for schema := range schemas {
+			w := g.schemas[schema]
+			w.version++
+			w.pending--
+		}
+	}
+}
+
+// version returns the version of the schema, it changes every time a write starts or
+// finishes
+func (g *writeGuard) version(schema string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	w, ok := g.schemas[schema]
+	if !ok {
+		return 0
+	}
+
+	return w.version
+}
+
+// set adds a model read from the Database at the version of its schema to the Cache.
+// The model is not added if a write started since it was read or is still in progress,
+// and it is removed if a write starts while it is added, as the write could have set a
+// newer copy first
+func (g *writeGuard) set(cache interfaces.Cache, model interfaces.Model, version uint64) error {
+	schema := model.GetSchema().Name
+
+	g.mu.Lock()
+	w, ok := g.schemas[schema]
+	busy := ok && (w.pending > 0 || w.version != version)
+	g.mu.Unlock()
+
+	if busy {
+		return nil
+	}
+
+	err := cache.Set(model, cache.GetTTL())
+	if err != nil {
+		return err
+	}
+
+	if g.version(schema) != version {
+		return cache.Delete(model)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"encoding/json"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// RawModel defines a model read from the outbox. Its type is unknown to the Relay, so
+// it is kept as the JSON it was stored with
+type RawModel struct {
+	Schema string
+	ID     string
+	Data   json.RawMessage
+}
+
+// GetSchema returns the schema of the model, its primary key is unknown
+func (m *RawModel) GetSchema() *interfaces.Schema {
+	return &interfaces.Schema{Name: m.Schema}
+}
+
+// GetID returns the ID of the model
+func (m *RawModel) GetID() string {
+	return m.ID
+}
+
+// Update replaces the model with the provided one
+func (m *RawModel) Update(i interface{}) error {
+	const op = "RawModel.Update"
+
+	model, ok := i.(*RawModel)
+	if !ok {
+		return ez.New(op, ez.EINVALID, "Provided interface is not of type RawModel", nil)
+	}
+
+	*m = *model
+	return nil
+}
+
+// Decode unmarshals the JSON of the model into v
+func (m *RawModel) Decode(v interface{}) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+// MarshalJSON returns the JSON the model was stored with
+func (m *RawModel) MarshalJSON() ([]byte, error) {
+	if m.Data == nil {
+		return []byte("null"), nil
+	}
+
+	return m.Data, nil
+}
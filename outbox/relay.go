@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/interfaces"
+)
+
+// Relay defines a worker that reads the events stored in the outbox of a Database and
+// hands them to a Publisher. Events are only marked as delivered after they are
+// published, so they are delivered at least once
+type Relay struct {
+	db        interfaces.OutboxDatabase
+	publisher interfaces.Publisher
+	batchSize int
+	interval  time.Duration
+}
+
+// NewRelay creates a new Relay that publishes the outbox of db
+func NewRelay(db interfaces.OutboxDatabase, publisher interfaces.Publisher) (*Relay, error) {
+	const op = "Outbox.NewRelay"
+
+	if db == nil || publisher == nil {
+		return nil, ez.New(op, ez.EINVALID, "Creating a Relay requires a database and a publisher", nil)
+	}
+
+	return &Relay{db: db, publisher: publisher, batchSize: 100, interval: time.Second}, nil
+}
+
+// SetBatchSize sets the maximum number of events published at once
+func (r *Relay) SetBatchSize(size int) error {
+	const op = "Outbox.Relay.SetBatchSize"
+
+	if size < 1 {
+		return ez.New(op, ez.EINVALID, "The batch size must be greater than 0", nil)
+	}
+
+	r.batchSize = size
+	return nil
+}
+
+// SetInterval sets how long Run waits before checking an empty outbox again
+func (r *Relay) SetInterval(interval time.Duration) error {
+	const op = "Outbox.Relay.SetInterval"
+
+	if interval <= 0 {
+		return ez.New(op, ez.EINVALID, "The interval must be greater than 0", nil)
+	}
+
+	r.interval = interval
+	return nil
+}
+
+// RelayOnce publishes a batch of pending events and returns how many were delivered.
+// The outbox is read and marked with the context if the database supports it. If the
+// database supports transactions the batch is relayed inside one, so databases that
+// lock the entries they read, such as pgdb, let several relays run at once without
+// publishing the same events
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	const op = "Outbox.Relay.RelayOnce"
+
+	db := r.database(ctx)
+
+	txdb, ok := db.(interfaces.TxDatabase)
+	if !ok {
+		return r.relay(ctx, db)
+	}
+
+	tx, err := txdb.Begin()
+	if err != nil {
+		return 0, ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	outboxTx, ok := tx.(interfaces.OutboxDatabase)
+	if !ok {
+		tx.Rollback()
+		return r.relay(ctx, db)
+	}
+
+	n, err := r.relay(ctx, outboxTx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// If commiting fails the events are published again by the next batch
+	err = tx.Commit()
+	if err != nil {
+		return 0, ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return n, nil
+}
+
+// database returns the outbox database bound to the context, if it supports it
+func (r *Relay) database(ctx context.Context) interfaces.OutboxDatabase {
+	ctxdb, ok := r.db.(interfaces.ContextDatabase)
+	if !ok {
+		return r.db
+	}
+
+	db, ok := ctxdb.WithContext(ctx).(interfaces.OutboxDatabase)
+	if !ok {
+		return r.db
+	}
+
+	return db
+}
+
+// relay publishes a batch of pending events of db and marks them as delivered
+func (r *Relay) relay(ctx context.Context, db interfaces.OutboxDatabase) (int, error) {
+	const op = "Outbox.Relay.RelayOnce"
+
+	entries, err := db.ReadOutbox(r.batchSize)
+	if err != nil {
+		return 0, ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	events := make([]interfaces.Event, len(entries))
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		events[i] = toEvent(entry)
+		ids[i] = entry.ID
+	}
+
+	err = r.publisher.Publish(ctx, events)
+	if err != nil {
+		return 0, ez.New(op, ez.EUNAVAILABLE, "Could not publish the outbox events", err)
+	}
+
+	// If marking fails the events are published again by the next batch
+	err = db.MarkDelivered(ids)
+	if err != nil {
+		return 0, ez.New(op, ez.ErrorCode(err), ez.ErrorMessage(err), err)
+	}
+
+	return len(entries), nil
+}
+
+// Run relays the outbox until the context is done. Full batches are relayed right
+// away, otherwise it waits for the interval. Errors are retried after the interval
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err == nil && n == r.batchSize {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// toEvent converts an outbox entry into an event, models are provided as RawModel
+func toEvent(entry interfaces.OutboxEntry) interfaces.Event {
+	event := interfaces.Event{
+		Schema: entry.Schema,
+		ID:     entry.ModelID,
+		Op:     entry.Op,
+		Time:   entry.Time,
+	}
+
+	if entry.Before != nil {
+		event.Before = &RawModel{Schema: entry.Schema, ID: entry.ModelID, Data: entry.Before}
+	}
+	if entry.After != nil {
+		event.After = &RawModel{Schema: entry.Schema, ID: entry.ModelID, Data: entry.After}
+	}
+
+	return event
+}
@@ -0,0 +1,111 @@
+package query
+
+// Comparison operators
+const (
+	EQ   = "="
+	NEQ  = "!="
+	GT   = ">"
+	GTE  = ">="
+	LT   = "<"
+	LTE  = "<="
+	LIKE = "LIKE"
+)
+
+// Logical operators
+const (
+	AND = "AND"
+	OR  = "OR"
+)
+
+// Condition defines a filter that models must satisfy to be part of the results of
+// a Query
+type Condition interface {
+	condition()
+}
+
+// Comparison compares a model field against a value
+type Comparison struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// Membership checks if a model field is equal to any of the values
+type Membership struct {
+	Field  string
+	Values []interface{}
+}
+
+// Group combines multiple conditions with a logical operator
+type Group struct {
+	Operator   string
+	Conditions []Condition
+}
+
+// Expr is a condition written in the native format of the database, values should
+// be passed as args using ? placeholders
+type Expr struct {
+	Expr string
+	Args []interface{}
+}
+
+func (Comparison) condition() {}
+func (Membership) condition() {}
+func (Group) condition()      {}
+func (Expr) condition()       {}
+
+// Eq checks that a field is equal to the value
+func Eq(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: EQ, Value: value}
+}
+
+// Neq checks that a field is not equal to the value
+func Neq(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: NEQ, Value: value}
+}
+
+// Gt checks that a field is greater than the value
+func Gt(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: GT, Value: value}
+}
+
+// Gte checks that a field is greater than or equal to the value
+func Gte(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: GTE, Value: value}
+}
+
+// Lt checks that a field is lower than the value
+func Lt(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: LT, Value: value}
+}
+
+// Lte checks that a field is lower than or equal to the value
+func Lte(field string, value interface{}) Condition {
+	return Comparison{Field: field, Operator: LTE, Value: value}
+}
+
+// Like checks that a field matches a pattern, where % matches any sequence of
+// characters and _ matches a single character
+func Like(field string, pattern string) Condition {
+	return Comparison{Field: field, Operator: LIKE, Value: pattern}
+}
+
+// In checks that a field is equal to any of the values
+func In(field string, values ...interface{}) Condition {
+	return Membership{Field: field, Values: values}
+}
+
+// And checks that all of the conditions are satisfied
+func And(conditions ...Condition) Condition {
+	return Group{Operator: AND, Conditions: conditions}
+}
+
+// Or checks that at least one of the conditions is satisfied
+func Or(conditions ...Condition) Condition {
+	return Group{Operator: OR, Conditions: conditions}
+}
+
+// Raw creates a condition written in the native format of the database
+func Raw(expr string, args ...interface{}) Condition {
+	return Expr{Expr: expr, Args: args}
+}
@@ -0,0 +1,88 @@
+package query
+
+// Query defines a backend neutral query. Each Database implementation translates it
+// into its native form
+type Query struct {
+	where  Condition
+	orders []Order
+	limit  int
+	offset int
+}
+
+// Order defines a field used to sort the results of a Query
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// New creates an empty Query that matches every model
+func New() *Query {
+	return &Query{}
+}
+
+// Where creates a new Query with the provided conditions, multiple conditions must
+// all be satisfied
+func Where(conditions ...Condition) *Query {
+	return New().Where(conditions...)
+}
+
+// Where adds conditions to the Query, they must be satisfied along with the existing ones
+func (q *Query) Where(conditions ...Condition) *Query {
+	if q.where != nil {
+		conditions = append([]Condition{q.where}, conditions...)
+	}
+
+	switch len(conditions) {
+	case 0:
+	case 1:
+		q.where = conditions[0]
+	default:
+		q.where = And(conditions...)
+	}
+
+	return q
+}
+
+// OrderBy sorts the results of the Query by a field in ascending order
+func (q *Query) OrderBy(field string) *Query {
+	q.orders = append(q.orders, Order{Field: field})
+	return q
+}
+
+// OrderByDesc sorts the results of the Query by a field in descending order
+func (q *Query) OrderByDesc(field string) *Query {
+	q.orders = append(q.orders, Order{Field: field, Desc: true})
+	return q
+}
+
+// Limit sets the maximum number of results of the Query, 0 means no limit
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// Offset sets the number of results to skip
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
+// GetWhere returns the condition of the Query, nil if it matches every model
+func (q *Query) GetWhere() Condition {
+	return q.where
+}
+
+// GetOrders returns the fields used to sort the results of the Query
+func (q *Query) GetOrders() []Order {
+	return q.orders
+}
+
+// GetLimit returns the maximum number of results of the Query
+func (q *Query) GetLimit() int {
+	return q.limit
+}
+
+// GetOffset returns the number of results to skip
+func (q *Query) GetOffset() int {
+	return q.offset
+}
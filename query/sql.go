@@ -0,0 +1,180 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/vanclief/ez"
+)
+
+// WhereSQL translates the condition of the Query into a SQL expression with ?
+// placeholders and its args. Returns an empty expression if the Query matches
+// every model, or an error if a condition uses an unknown operator
+func (q *Query) WhereSQL() (string, []interface{}, error) {
+	const op = "Query.WhereSQL"
+
+	if q.where == nil {
+		return "", nil, nil
+	}
+
+	var b strings.Builder
+	args, err := writeCondition(&b, q.where, nil)
+	if err != nil {
+		return "", nil, ez.New(op, ez.EINVALID, ez.ErrorMessage(err), err)
+	}
+
+	return b.String(), args, nil
+}
+
+// OrderSQL translates the order of the Query into a SQL expression, returns an
+// empty expression if the results are not sorted
+func (q *Query) OrderSQL() string {
+	orders := make([]string, len(q.orders))
+	for i, o := range q.orders {
+		orders[i] = QuoteIdent(o.Field)
+		if o.Desc {
+			orders[i] += " DESC"
+		}
+	}
+
+	return strings.Join(orders, ", ")
+}
+
+// QuoteIdent escapes an identifier such as a table or column name
+func QuoteIdent(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+// writeCondition writes the SQL of a condition and returns the args with the values
+// of its placeholders. Operators are written as they are, so only the known ones are
+// accepted
+func writeCondition(b *strings.Builder, c Condition, args []interface{}) ([]interface{}, error) {
+	const op = "Query.writeCondition"
+
+	switch cond := c.(type) {
+	case Comparison:
+		switch cond.Operator {
+		case EQ, NEQ, GT, GTE, LT, LTE, LIKE:
+		default:
+			msg := fmt.Sprintf("Operator %s is not supported", cond.Operator)
+			return nil, ez.New(op, ez.EINVALID, msg, nil)
+		}
+
+		b.WriteString(QuoteIdent(cond.Field))
+
+		// NULL can not be compared using = or !=
+		if cond.Value == nil && (cond.Operator == EQ || cond.Operator == NEQ) {
+			if cond.Operator == EQ {
+				b.WriteString(" IS NULL")
+			} else {
+				b.WriteString(" IS NOT NULL")
+			}
+			return args, nil
+		}
+
+		b.WriteString(" " + cond.Operator + " ?")
+		args = append(args, cond.Value)
+	case Membership:
+		if len(cond.Values) == 0 {
+			b.WriteString("1 = 0")
+			return args, nil
+		}
+
+		b.WriteString(QuoteIdent(cond.Field) + " IN (")
+		for i, value := range cond.Values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("?")
+			args = append(args, value)
+		}
+		b.WriteString(")")
+	case Group:
+		if cond.Operator != AND && cond.Operator != OR {
+			msg := fmt.Sprintf("Operator %s is not supported", cond.Operator)
+			return nil, ez.New(op, ez.EINVALID, msg, nil)
+		}
+
+		if len(cond.Conditions) == 0 {
+			// An empty AND is always satisfied while an empty OR never is
+			if cond.Operator == OR {
+				b.WriteString("1 = 0")
+			} else {
+				b.WriteString("1 = 1")
+			}
+			return args, nil
+		}
+
+		b.WriteString("(")
+		for i, sub := range cond.Conditions {
+			if i > 0 {
+				b.WriteString(" " + cond.Operator + " ")
+			}
+			var err error
+			args, err = writeCondition(b, sub, args)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b.WriteString(")")
+	case Expr:
+		b.WriteString("(" + cond.Expr + ")")
+		args = append(args, cond.Args...)
+	}
+
+	return args, nil
+}
+
+// String returns a readable representation of the Query, two queries with the same
+// representation return the same results. Pointer args are represented by the values
+// they point to, so the representation does not depend on their addresses
+func (q *Query) String() string {
+	var b strings.Builder
+
+	where, args, err := q.WhereSQL()
+	if err != nil {
+		b.WriteString("INVALID " + ez.ErrorMessage(err))
+	} else if where != "" {
+		b.WriteString("WHERE " + where)
+	}
+
+	order := q.OrderSQL()
+	if order != "" {
+		b.WriteString(" ORDER BY " + order)
+	}
+
+	if q.limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %d", q.limit)
+	}
+
+	if q.offset > 0 {
+		fmt.Fprintf(&b, " OFFSET %d", q.offset)
+	}
+
+	if len(args) > 0 {
+		fmt.Fprintf(&b, " ARGS %#v", argValues(args))
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// argValues returns the args with the pointers replaced by the values they point to,
+// nil pointers are kept
+func argValues(args []interface{}) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		v := reflect.ValueOf(arg)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+
+		if v.IsValid() && v.CanInterface() {
+			values[i] = v.Interface()
+		} else {
+			values[i] = arg
+		}
+	}
+
+	return values
+}
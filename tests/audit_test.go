@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/audit"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/manager"
+)
+
+func TestAuditFileSink(t *testing.T) {
+	// Test Setup
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	sink, err := audit.NewFileSink(path)
+	assert.Nil(t, err)
+
+	state := NewMockManagerWithMemDB()
+	state.SetAuditSink(sink)
+	ctx := manager.WithActor(context.Background(), "franco")
+
+	// Should write a record for every commited change
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	err = state.CommitContext(ctx)
+	assert.Nil(t, err)
+
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	err = state.CommitContext(ctx)
+	assert.Nil(t, err)
+
+	// Should write a record for every rolled back change
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = sink.Close()
+	assert.Nil(t, err)
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	records := []interfaces.AuditRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := interfaces.AuditRecord{}
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		assert.Nil(t, err)
+		records = append(records, record)
+	}
+	assert.Len(t, records, 3)
+
+	assert.Equal(t, "users", records[0].Schema)
+	assert.Equal(t, "1", records[0].ID)
+	assert.Equal(t, "insert", records[0].Op)
+	assert.Equal(t, "franco", records[0].Actor)
+	assert.JSONEq(t, `{
+		"id": {"before": null, "after": "1"},
+		"name": {"before": null, "after": "Franco"},
+		"email": {"before": null, "after": "franco@gmail.com"}
+	}`, string(records[0].Diff))
+
+	assert.Equal(t, "update", records[1].Op)
+	assert.JSONEq(t, `{"name": {"before": "Franco", "after": "Not Franco"}}`, string(records[1].Diff))
+
+	assert.Equal(t, "update", records[2].Op)
+	assert.Equal(t, "", records[2].Actor)
+	assert.JSONEq(t, `{"name": {"before": "Not Franco", "after": "Franco"}}`, string(records[2].Diff))
+}
+
+// txAuditSink records the audit records written inside and outside of transactions
+type txAuditSink struct {
+	written   []interfaces.AuditRecord
+	writtenTx []interfaces.AuditRecord
+	failing   bool
+	rejectsTx bool
+}
+
+func (s *txAuditSink) SupportsTx(db interfaces.Database) bool {
+	return !s.rejectsTx
+}
+
+func (s *txAuditSink) Write(ctx context.Context, records []interfaces.AuditRecord) error {
+	s.written = append(s.written, records...)
+	return nil
+}
+
+func (s *txAuditSink) WriteTx(ctx context.Context, tx interfaces.TxDatabase, records []interfaces.AuditRecord) error {
+	if s.failing {
+		return ez.New("txAuditSink.WriteTx", ez.EUNAVAILABLE, "The audit log is unavailable", nil)
+	}
+
+	s.writtenTx = append(s.writtenTx, records...)
+	return nil
+}
+
+func TestAuditTxSinkWithMemDB(t *testing.T) {
+	// Test Setup
+	sink := &txAuditSink{}
+	state := NewMockManagerWithMemDB()
+	state.SetAuditSink(sink)
+
+	// Should write the records inside the transaction of the changes
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, sink.writtenTx, 1)
+	assert.Len(t, sink.written, 0)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+	assert.Len(t, sink.writtenTx, 2)
+	assert.Equal(t, "delete", sink.writtenTx[1].Op)
+
+	// Should not persist the changes if their records can not be written
+	sink.failing = true
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+	assert.Equal(t, manager.PENDING, state.Status()[0].Status())
+
+	res := &user.User{}
+	err = state.DB.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+	assert.Len(t, sink.written, 0)
+	// Should write the records after the commit if the sink can not use the transaction
+	sink = &txAuditSink{failing: true, rejectsTx: true}
+	state.SetAuditSink(sink)
+
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, sink.writtenTx, 0)
+	assert.Len(t, sink.written, 1)
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/caches/redis"
+	"github.com/vanclief/state/caches/simplecache"
+	"github.com/vanclief/state/caches/tiered"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/invalidation"
+	"github.com/vanclief/state/manager"
+	"github.com/vanclief/state/query"
+)
+
+// NewMockNode returns a Manager with its own in-process cache that shares the database
+// and the invalidation channel with other nodes
+func NewMockNode(ctx context.Context, t *testing.T, db interfaces.Database, addr string) (*manager.Manager, *simplecache.Cache) {
+	cache := simplecache.New()
+	client := goredis.NewClient(&goredis.Options{Addr: addr})
+
+	invalidator, err := invalidation.New(client, "invalidations", cache, manager.QueryGeneration)
+	assert.Nil(t, err)
+
+	err = invalidator.Listen(ctx)
+	assert.Nil(t, err)
+
+	state, err := manager.New(db, cache)
+	assert.Nil(t, err)
+	state.AddPublisher(invalidator)
+
+	return state, cache
+}
+
+func TestInvalidationWithMiniredis(t *testing.T) {
+	// Test Setup
+	server, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := NewTestMemDatabase()
+	node1, cache1 := NewMockNode(ctx, t, db, server.Addr())
+	node2, cache2 := NewMockNode(ctx, t, db, server.Addr())
+
+	node1.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = node1.Commit()
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = node2.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cache2.Len())
+
+	// Should evict the stale copy from the cache of the other node
+	node1.Stage(user.New("1", "Franco", "franco@francovalencia.com"), "update")
+	err = node1.Commit()
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return cache2.Len() == 0 }, time.Second, 5*time.Millisecond)
+
+	err = node2.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@francovalencia.com", res.Email)
+
+	// Should ignore the messages sent by the same node
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, cache1.Len())
+
+	// Should evict deleted models
+	node1.Stage(user.New("1", "Franco", "franco@francovalencia.com"), "delete")
+	err = node1.Commit()
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool { return cache2.Len() == 0 }, time.Second, 5*time.Millisecond)
+
+	err = node2.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestQueryInvalidationWithMiniredis(t *testing.T) {
+	// Test Setup
+	server, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := NewTestMemDatabase()
+	node1, _ := NewMockNode(ctx, t, db, server.Addr())
+	node2, _ := NewMockNode(ctx, t, db, server.Addr())
+	node1.ToggleQueryCache()
+	node2.ToggleQueryCache()
+
+	node1.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = node1.Commit()
+	assert.Nil(t, err)
+
+	list := []user.User{}
+	err = node2.Query(&list, &user.User{}, query.New())
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+
+	// Should discard the cached query results of the schemas changed by the other node
+	node1.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = node1.Commit()
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		list := []user.User{}
+		err := node2.Query(&list, &user.User{}, query.New())
+		return err == nil && len(list) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// Should reject a tiered cache, as every node would remove the models from L2
+	l2, err := redis.New(server.Addr(), "", 0)
+	assert.Nil(t, err)
+
+	cache, err := tiered.New(simplecache.New(), l2)
+	assert.Nil(t, err)
+
+	_, err = invalidation.New(goredis.NewClient(&goredis.Options{Addr: server.Addr()}), "invalidations", cache, nil)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
@@ -0,0 +1,1647 @@
+package tests
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/databases/memdb"
+	"github.com/vanclief/state/examplemodels/article"
+	"github.com/vanclief/state/examplemodels/book"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/manager"
+	"github.com/vanclief/state/outbox"
+	"github.com/vanclief/state/query"
+)
+
+func NewTestMemDatabase() interfaces.Database {
+	db := memdb.New()
+
+	dbModels := []interface{}{(&user.User{})}
+
+	// Create the database schema
+	err := db.CreateSchema(dbModels, true)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+func NewMockManagerWithMemDB() *manager.Manager {
+	db := NewTestMemDatabase()
+	cache := NewTestCache()
+
+	state, err := manager.New(db, cache)
+	if err != nil {
+		panic(err)
+	}
+
+	return state
+}
+
+func TestCommitWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+
+	// Should be able to apply insert
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, state.Status(), 0)
+	assert.Len(t, state.Applied(), 2)
+
+	// Should be able to apply update
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = state.DB.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+
+	// Should be able to apply delete
+	state.Stage(user2, "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.DB.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not apply any change if one of them fails
+	user3 := user.New("3", "Jacob", "jacob@gmail.com")
+	book := book.New("1", "El master fuster", "Franco") // Book is not in the database schema
+	state.Stage(user3, "insert")
+	state.Stage(book, "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+	assert.Len(t, state.Applied(), 0)
+	assert.Len(t, state.Status(), 2)
+
+	err = state.DB.Get(res, "3")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestRollbackWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+
+	// Should be able to rollback update and delete changes
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	state.Stage(user2, "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+	assert.Len(t, state.Applied(), 0)
+
+	res := &user.User{}
+	err = state.DB.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	err = state.Cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	res = &user.User{}
+	err = state.DB.Get(res, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jack", res.Name)
+
+	// Should be able to rollback insert changes
+	user3 := user.New("3", "Jacob", "jacob@gmail.com")
+	state.Stage(user3, "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = state.Get(res, "3")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestQueryOneWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	state.Commit()
+
+	// Should be able to get a model that exists
+	res := &user.User{}
+	err := state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
+	assert.Nil(t, err)
+	assert.Equal(t, user1.ID, res.ID)
+	assert.Equal(t, user1.Name, res.Name)
+
+	// Should fail if there is no model that matches the query
+	res = &user.User{}
+	err = state.QueryOne(res, query.Where(query.Eq("email", "arcano@gmail.com")))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should fail if there is more than one model that matches the query
+	user2 := user.New("2", "Franco's Impostor", "franco@gmail.com")
+	state.Stage(user2, "insert")
+	state.Commit()
+
+	res = &user.User{}
+	err = state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+
+	// Should be able to use a raw condition with its arguments
+	res = &user.User{}
+	err = state.QueryOneRaw(res, "name = ?", "Franco's Impostor")
+	assert.Nil(t, err)
+	assert.Equal(t, user2.ID, res.ID)
+
+	list := []user.User{}
+	err = state.QueryRaw(&list, &user.User{}, "email = ?", "franco@gmail.com")
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestQueryWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	user1 := user.New("1", "Franco", "email@francovalencia.com")
+	user2 := user.New("2", "Franco", "franco@gmail.com")
+	user3 := user.New("3", "Vanclief", "vanclief@vanclief.com")
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	state.Stage(user3, "insert")
+	state.Commit()
+
+	// Should be able to get the models that satisfy the query
+	res := []user.User{}
+	err := state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")))
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, user1.ID, res[0].ID)
+	assert.Equal(t, user2.ID, res[1].ID)
+
+	// Should be able to combine conditions
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Or(query.Eq("name", "Vanclief"), query.And(query.Eq("name", "Franco"), query.Like("email", "%gmail.com")))))
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, user2.ID, res[0].ID)
+	assert.Equal(t, user3.ID, res[1].ID)
+
+	// Should fail if the query is invalid
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Raw(`default default default`)))
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should be able to use memberships and raw conditions
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.In("id", "1", "3"), query.Raw("email != ?", "vanclief@vanclief.com")))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user1.ID, res[0].ID)
+
+	// Should fail if there is no model that matches the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Francisco")))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should be able to use limit and offset in the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1).Offset(1))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user2.ID, res[0].ID)
+
+	// Should be able to use limit with order by in the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).OrderByDesc("email").Limit(1))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user2.ID, res[0].ID)
+}
+
+func TestGetWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	err := state.DB.Insert(user.New("1", "Franco", "franco@gmail.com"))
+	assert.Nil(t, err)
+
+	// Should add the model to the cache after reading it from the database
+	res := &user.User{}
+	err = state.Cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	res = &user.User{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	cached := &user.User{}
+	err = state.Cache.Get(cached, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", cached.Name)
+
+	// Should serve the model from the cache
+	err = state.DB.Update(user.New("1", "Not Franco", "franco@gmail.com"))
+	assert.Nil(t, err)
+
+	res = &user.User{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	// Should be able to skip the cache
+	res = &user.User{}
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+
+	cached = &user.User{}
+	err = state.Cache.Get(cached, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", cached.Name)
+
+	// Should be able to refresh the cache
+	res = &user.User{}
+	err = state.Get(res, "1", manager.RefreshCache())
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+
+	cached = &user.User{}
+	err = state.Cache.Get(cached, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", cached.Name)
+
+	// Should not be able to get a model that doesnt exist
+	res = &user.User{}
+	err = state.Get(res, "31231")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestQueryCacheWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	state.ToggleQueryCache()
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("2", "Franco", "email@francovalencia.com"), "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+
+	q := query.Where(query.Eq("name", "Franco")).OrderBy("id")
+
+	res := []user.User{}
+	err = state.Query(&res, &user.User{}, q)
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+
+	one := &user.User{}
+	err = state.QueryOne(one, query.Where(query.Eq("id", "1")))
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", one.Name)
+
+	// Should serve the results from the cache
+	err = state.DB.Delete(user.New("2", "Franco", "email@francovalencia.com"))
+	assert.Nil(t, err)
+	err = state.DB.Update(user.New("1", "Not Franco", "franco@gmail.com"))
+	assert.Nil(t, err)
+
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, q)
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+
+	one = &user.User{}
+	err = state.QueryOne(one, query.Where(query.Eq("id", "1")))
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", one.Name)
+
+	// Should invalidate the results after commiting changes to the schema
+	state.Stage(user.New("3", "Franco", "franco@francovalencia.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, q)
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "3", res[0].ID)
+
+	one = &user.User{}
+	err = state.QueryOne(one, query.Where(query.Eq("id", "1")))
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", one.Name)
+
+	// Should invalidate the results after a rollback
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, q)
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should cache the results of pointer args by their values
+	state.Stage(user.New("4", "Jack", "jack@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	id := "1"
+	one = &user.User{}
+	err = state.QueryOne(one, query.Where(query.Eq("id", &id)))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", one.ID)
+
+	id = "4"
+	one = &user.User{}
+	err = state.QueryOne(one, query.Where(query.Eq("id", &id)))
+	assert.Nil(t, err)
+	assert.Equal(t, "4", one.ID)
+}
+
+func TestUnitOfWorkWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	work1 := state.Begin()
+	work2 := state.Begin()
+
+	// Should keep the staged changes of each unit of work separated
+	work1.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	work2.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	assert.Len(t, work1.Status(), 1)
+	assert.Len(t, work2.Status(), 1)
+	assert.Len(t, state.Status(), 0)
+
+	err := work1.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, work1.Applied(), 1)
+	assert.Len(t, work2.Status(), 1)
+
+	res := &user.User{}
+	err = state.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should only rollback the changes of its unit of work
+	err = work2.Commit()
+	assert.Nil(t, err)
+
+	err = work1.Rollback()
+	assert.Nil(t, err)
+
+	res = &user.User{}
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	res = &user.User{}
+	err = state.Get(res, "2", manager.SkipCache())
+	assert.Nil(t, err)
+}
+
+func TestConcurrentUnitsOfWorkWithMemDB(t *testing.T) {
+	// Test Setup
+	state, err := manager.New(NewTestMemDatabase(), nil)
+	assert.Nil(t, err)
+
+	// Should be able to commit units of work from multiple goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := strconv.Itoa(i)
+			work := state.Begin()
+			work.Stage(user.New(id, "Franco", id+"@gmail.com"), "insert")
+			assert.Nil(t, work.Commit())
+
+			res := &user.User{}
+			assert.Nil(t, state.Get(res, id))
+		}(i)
+	}
+	wg.Wait()
+
+	res := []user.User{}
+	err = state.Query(&res, &user.User{}, query.New())
+	assert.Nil(t, err)
+	assert.Len(t, res, 50)
+}
+
+func TestContextWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err := state.CommitContext(context.Background())
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Should not read with a canceled context
+	res := &user.User{}
+	err = state.GetContext(ctx, res, "1")
+	assert.NotNil(t, err)
+
+	list := []user.User{}
+	err = state.QueryContext(ctx, &list, &user.User{}, query.New())
+	assert.NotNil(t, err)
+
+	// Should not commit with a canceled context
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = state.CommitContext(ctx)
+	assert.NotNil(t, err)
+	assert.Len(t, state.Status(), 1)
+
+	res = &user.User{}
+	err = state.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should discard a transaction if its context is canceled before the commit
+	db := memdb.New()
+	err = db.CreateSchema([]interface{}{&user.User{}}, true)
+	assert.Nil(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	tx, err := db.WithContext(ctx).(interfaces.TxDatabase).Begin()
+	assert.Nil(t, err)
+
+	err = tx.Insert(user.New("3", "Vanclief", "vanclief@vanclief.com"))
+	assert.Nil(t, err)
+
+	cancel()
+	err = tx.Commit()
+	assert.NotNil(t, err)
+
+	res = &user.User{}
+	err = db.Get(res, "3")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should be able to begin a new transaction after the canceled one
+	tx, err = db.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Rollback())
+}
+
+func TestTransactionWithMemDB(t *testing.T) {
+	// Test Setup
+	db := memdb.New()
+	err := db.CreateSchema([]interface{}{&user.User{}}, true)
+	assert.Nil(t, err)
+
+	err = db.Insert(user.New("1", "Franco", "franco@gmail.com"))
+	assert.Nil(t, err)
+	err = db.WriteOutbox([]interfaces.Event{{Schema: "users", ID: "1", Op: "insert"}})
+	assert.Nil(t, err)
+
+	tx, err := db.Begin()
+	assert.Nil(t, err)
+
+	err = tx.Insert(user.New("2", "Jack", "jack@gmail.com"))
+	assert.Nil(t, err)
+	err = tx.Update(user.New("1", "Not Franco", "franco@gmail.com"))
+	assert.Nil(t, err)
+	err = tx.(interfaces.OutboxDatabase).WriteOutbox([]interfaces.Event{{Schema: "users", ID: "2", Op: "insert"}})
+	assert.Nil(t, err)
+
+	// Should read its own writes while the original database does not see them
+	res := &user.User{}
+	err = tx.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+
+	err = db.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should keep the writes made directly to the original database during the transaction
+	err = db.Insert(user.New("3", "Vanclief", "vanclief@vanclief.com"))
+	assert.Nil(t, err)
+	err = db.MarkDelivered([]int64{1})
+	assert.Nil(t, err)
+
+	err = tx.Commit()
+	assert.Nil(t, err)
+
+	list := []user.User{}
+	err = db.Query(&list, &user.User{}, query.New())
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+	assert.Equal(t, "Not Franco", list[0].Name)
+	assert.Equal(t, "3", list[1].ID)
+	assert.Equal(t, "2", list[2].ID)
+
+	entries, err := db.ReadOutbox(10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(2), entries[0].ID)
+	assert.Equal(t, "2", entries[0].ModelID)
+
+	// Should discard the writes of a rolled back transaction only
+	tx, err = db.Begin()
+	assert.Nil(t, err)
+
+	err = tx.Delete(user.New("2", "Jack", "jack@gmail.com"))
+	assert.Nil(t, err)
+	err = db.Delete(user.New("3", "Vanclief", "vanclief@vanclief.com"))
+	assert.Nil(t, err)
+
+	err = tx.Rollback()
+	assert.Nil(t, err)
+
+	err = db.Get(res, "2")
+	assert.Nil(t, err)
+	err = db.Get(res, "3")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+// trackedArticle counts the calls to the after hooks of an Article
+type trackedArticle struct {
+	*article.Article
+	inserted int
+	updated  int
+	deleted  int
+}
+
+func (a *trackedArticle) AfterInsert() { a.inserted++ }
+func (a *trackedArticle) AfterUpdate() { a.updated++ }
+func (a *trackedArticle) AfterDelete() { a.deleted++ }
+
+func TestLifecycleHooksWithMemDB(t *testing.T) {
+	// Test Setup
+	db := memdb.New()
+	err := db.CreateSchema([]interface{}{&article.Article{}}, true)
+	assert.Nil(t, err)
+
+	state, err := manager.New(db, nil)
+	assert.Nil(t, err)
+
+	// Should not stage an invalid model
+	err = state.Stage(article.New("1", ""), "insert")
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+	assert.Len(t, state.Status(), 0)
+
+	// Should run the hooks when inserting
+	a := &trackedArticle{Article: article.New("1", "Hooks")}
+	err = state.Stage(a, "insert")
+	assert.Nil(t, err)
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.False(t, a.CreatedAt.IsZero())
+	assert.Equal(t, 1, a.inserted)
+
+	res := &article.Article{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, a.CreatedAt.Unix(), res.CreatedAt.Unix())
+
+	// Should run the hooks when updating
+	a.Published = true
+	err = state.Stage(a, "update")
+	assert.Nil(t, err)
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, a.updated)
+
+	// Should not delete a model if its before hook fails
+	err = state.Stage(a, "delete")
+	assert.Nil(t, err)
+	err = state.Commit()
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+	assert.Equal(t, 0, a.deleted)
+
+	res = &article.Article{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+
+	// Should run the hooks when deleting
+	state.Clear()
+	a.Published = false
+	err = state.Stage(a, "delete")
+	assert.Nil(t, err)
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, a.deleted)
+}
+
+func TestHooksWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+
+	var applied []string
+	var commited, reverted int
+	vetoCommit := false
+
+	state.Use(manager.Hooks{
+		BeforeStage: func(ctx context.Context, change *manager.Change) error {
+			if change.Model().(*user.User).Name == "Blocked" {
+				return ez.New("BeforeStage", ez.ENOTAUTHORIZED, "User is blocked", nil)
+			}
+			return nil
+		},
+		BeforeCommit: func(ctx context.Context, changes []*manager.Change) error {
+			if vetoCommit {
+				return ez.New("BeforeCommit", ez.ENOTAUTHORIZED, "Commit is not allowed", nil)
+			}
+			for _, change := range changes {
+				change.Annotate("actor", "admin")
+			}
+			return nil
+		},
+		AfterApply: func(ctx context.Context, change *manager.Change) {
+			applied = append(applied, change.Operation()+":"+change.Model().GetID()+":"+change.Annotations()["actor"].(string))
+		},
+		AfterCommit: func(ctx context.Context, changes []*manager.Change, err error) {
+			commited += len(changes)
+		},
+		OnRollback: func(ctx context.Context, changes []*manager.Change, err error) {
+			reverted += len(changes)
+		},
+	})
+
+	// Should be able to veto staging a change
+	err := state.Stage(user.New("1", "Blocked", "blocked@gmail.com"), "insert")
+	assert.Equal(t, ez.ENOTAUTHORIZED, ez.ErrorCode(err))
+	assert.Len(t, state.Status(), 0)
+
+	// Should be able to veto a commit
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	vetoCommit = true
+	err = state.Commit()
+	assert.Equal(t, ez.ENOTAUTHORIZED, ez.ErrorCode(err))
+	assert.Len(t, applied, 0)
+	assert.Equal(t, 0, commited)
+
+	res := &user.User{}
+	err = state.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should run the hooks with the annotated changes
+	vetoCommit = false
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"insert:1:admin", "insert:2:admin"}, applied)
+	assert.Equal(t, 2, commited)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, reverted)
+	assert.Len(t, state.Applied(), 0)
+}
+
+// eventRecorder is a Publisher that keeps the published events
+type eventRecorder struct {
+	events []interfaces.Event
+}
+
+func (r *eventRecorder) Publish(ctx context.Context, events []interfaces.Event) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+func TestEventsWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	events, unsubscribe, err := state.Subscribe(10)
+	assert.Nil(t, err)
+	recorder := &eventRecorder{}
+	state.AddPublisher(recorder)
+
+	// Should receive an event for every commited change
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	event := <-events
+	assert.Equal(t, "users", event.Schema)
+	assert.Equal(t, "1", event.ID)
+	assert.Equal(t, "insert", event.Op)
+	assert.Nil(t, event.Before)
+	assert.Equal(t, "Franco", event.After.(*user.User).Name)
+
+	event = <-events
+	assert.Equal(t, "update", event.Op)
+	assert.Equal(t, "Franco", event.Before.(*user.User).Name)
+	assert.Equal(t, "Not Franco", event.After.(*user.User).Name)
+
+	// Should not receive events for changes that were not commited
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+	state.Clear()
+	assert.Len(t, events, 0)
+
+	// Should receive the opposite operation when a change is rolled back
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	<-events
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	event = <-events
+	assert.Equal(t, "delete", event.Op)
+	assert.Equal(t, "2", event.ID)
+	assert.Nil(t, event.After)
+
+	// Should deliver the same events to the publishers
+	assert.Len(t, recorder.events, 4)
+
+	// Should close the channel after unsubscribing
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestSlowSubscriberWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+
+	// Should reject a subscription without a buffer
+	_, _, err := state.Subscribe(0)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should not block the commit and count the events a slow subscriber could not receive
+	events, unsubscribe, err := state.Subscribe(1)
+	assert.Nil(t, err)
+	defer unsubscribe()
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("3", "Jacob", "jacob@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), state.DroppedEvents())
+
+	event := <-events
+	assert.Equal(t, "1", event.ID)
+
+	// Should deliver the next events once the subscriber catches up
+	state.Stage(user.New("4", "Jill", "jill@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), state.DroppedEvents())
+
+	event = <-events
+	assert.Equal(t, "4", event.ID)
+}
+
+// failingPublisher is a Publisher that is always unavailable
+type failingPublisher struct{}
+
+func (p *failingPublisher) Publish(ctx context.Context, events []interfaces.Event) error {
+	return ez.New("failingPublisher.Publish", ez.EUNAVAILABLE, "Publisher is unavailable", nil)
+}
+
+func TestOutboxWithMemDB(t *testing.T) {
+	// Test Setup
+	db := memdb.New()
+	err := db.CreateSchema([]interface{}{&user.User{}}, true)
+	assert.Nil(t, err)
+
+	state, err := manager.New(db, nil)
+	assert.Nil(t, err)
+	state.ToggleOutbox()
+
+	// Should store the events of the commited changes in the outbox
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	// Should not store the events of failed commits
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+	state.Clear()
+
+	entries, err := db.ReadOutbox(10)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	// Should not mark the events as delivered if they could not be published
+	relay, err := outbox.NewRelay(db, &failingPublisher{})
+	assert.Nil(t, err)
+
+	n, err := relay.RelayOnce(context.Background())
+	assert.Equal(t, ez.EUNAVAILABLE, ez.ErrorCode(err))
+	assert.Equal(t, 0, n)
+
+	// Should not read the outbox once the context is done
+	recorder := &eventRecorder{}
+	relay, err = outbox.NewRelay(db, recorder)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	n, err = relay.RelayOnce(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, recorder.events, 0)
+
+	// Should publish the events and mark them as delivered
+
+	n, err = relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, recorder.events, 2)
+	assert.Equal(t, "insert", recorder.events[0].Op)
+	assert.Equal(t, "update", recorder.events[1].Op)
+
+	before := &user.User{}
+	err = recorder.events[1].Before.(*outbox.RawModel).Decode(before)
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", before.Name)
+
+	after := &user.User{}
+	err = recorder.events[1].After.(*outbox.RawModel).Decode(after)
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", after.Name)
+
+	n, err = relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// Should store the events of rolled back changes
+	state.Stage(user.New("3", "Vanclief", "vanclief@vanclief.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	n, err = relay.RelayOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "insert", recorder.events[2].Op)
+	assert.Equal(t, "delete", recorder.events[3].Op)
+
+	// Should not commit if the database does not support an outbox
+	state, err = manager.New(NewTestSQLiteDatabase(), nil)
+	assert.Nil(t, err)
+	state.ToggleOutbox()
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestPlanWithMemDB(t *testing.T) {
+	// Test Setup
+	db := memdb.New()
+	err := db.CreateSchema([]interface{}{&user.User{}, &article.Article{}}, true)
+	assert.Nil(t, err)
+
+	state, err := manager.New(db, NewTestCache())
+	assert.Nil(t, err)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	published := article.New("1", "Published")
+	published.Published = true
+	err = db.Insert(published)
+	assert.Nil(t, err)
+
+	// Should predict the outcome of every staged change
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("2", "Jack", "jack@wick.com"), "update")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("3", "Vanclief", "vanclief@vanclief.com"), "delete")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "delete")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "update")
+	state.Stage(published, "delete")
+
+	plan, err := state.Plan()
+	assert.Nil(t, err)
+	assert.False(t, plan.OK())
+	assert.Len(t, plan.Steps, 7)
+	assert.Len(t, plan.Failed(), 4)
+
+	assert.Equal(t, manager.APPLY, plan.Steps[0].Outcome)
+	assert.Equal(t, manager.APPLY, plan.Steps[1].Outcome)
+	assert.Equal(t, manager.FAIL, plan.Steps[2].Outcome)
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(plan.Steps[2].Err))
+	assert.Equal(t, manager.FAIL, plan.Steps[3].Outcome)
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(plan.Steps[3].Err))
+	assert.Equal(t, manager.APPLY, plan.Steps[4].Outcome)
+	assert.Equal(t, manager.FAIL, plan.Steps[5].Outcome)
+	assert.Equal(t, manager.FAIL, plan.Steps[6].Outcome)
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(plan.Steps[6].Err))
+
+	// Should not write to the database or cache
+	assert.Len(t, state.Status(), 7)
+
+	res := &user.User{}
+	err = state.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	res = &user.User{}
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+
+	// Should not modify the staged models when running their hooks
+	state.Clear()
+	a := article.New("2", "Plan")
+	state.Stage(a, "insert")
+
+	plan, err = state.Plan()
+	assert.Nil(t, err)
+	assert.True(t, plan.OK())
+	assert.True(t, a.CreatedAt.IsZero())
+
+	// Should predict the changes vetoed by the hooks of the Manager as failures
+	state.Clear()
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("3", "Jacob", "jacob@gmail.com"), "insert")
+
+	state.Use(manager.Hooks{
+		BeforeStage: func(ctx context.Context, change *manager.Change) error {
+			change.Annotate("planned", true)
+			if change.Model().GetID() == "3" {
+				return ez.New("BeforeStage", ez.ENOTAUTHORIZED, "Jacob is not allowed", nil)
+			}
+			return nil
+		},
+	})
+
+	plan, err = state.Plan()
+	assert.Nil(t, err)
+	assert.Equal(t, manager.APPLY, plan.Steps[0].Outcome)
+	assert.Equal(t, manager.FAIL, plan.Steps[1].Outcome)
+	assert.Equal(t, ez.ENOTAUTHORIZED, ez.ErrorCode(plan.Steps[1].Err))
+	assert.Len(t, plan.Steps[0].Change.Annotations(), 0)
+
+	vetoed := false
+	state.Use(manager.Hooks{
+		BeforeCommit: func(ctx context.Context, changes []*manager.Change) error {
+			if vetoed {
+				return ez.New("BeforeCommit", ez.ECONFLICT, "Commits are frozen", nil)
+			}
+			return nil
+		},
+	})
+
+	vetoed = true
+	plan, err = state.Plan()
+	assert.Nil(t, err)
+	assert.Len(t, plan.Failed(), 2)
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(plan.Steps[0].Err))
+	assert.Equal(t, ez.ENOTAUTHORIZED, ez.ErrorCode(plan.Steps[1].Err))
+}
+
+func TestCoalescingWithMemDB(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithMemDB()
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+
+	// Should not merge changes unless coalescing is enabled
+	state.Stage(user.New("1", "Not Franco", "franco@gmail.com"), "update")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "update")
+	assert.Len(t, state.Status(), 2)
+	state.Clear()
+
+	stage := 0
+	state.Use(manager.Hooks{
+		BeforeStage: func(ctx context.Context, change *manager.Change) error {
+			stage++
+			change.Annotate("stage", stage)
+			change.Annotate(change.Operation(), stage)
+			return nil
+		},
+	})
+	state.ToggleCoalescing()
+
+	// Should merge an insert followed by updates into a single insert
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("2", "Jack", "jack@wick.com"), "update")
+	state.Stage(user.New("2", "John", "jack@wick.com"), "update")
+	assert.Len(t, state.Status(), 1)
+	assert.Equal(t, "insert", state.Status()[0].Operation())
+	assert.Equal(t, "John", state.Status()[0].Model().(*user.User).Name)
+
+	// Should cancel an insert followed by a delete
+	state.Stage(user.New("3", "Vanclief", "vanclief@vanclief.com"), "insert")
+	state.Stage(user.New("3", "Vanclief", "vanclief@vanclief.com"), "delete")
+	assert.Len(t, state.Status(), 1)
+
+	// Should collapse repeated updates and replace an update followed by a delete
+	state.Stage(user.New("1", "Not Franco", "franco@gmail.com"), "update")
+	state.Stage(user.New("1", "Franco", "franco@francovalencia.com"), "update")
+	assert.Len(t, state.Status(), 2)
+	assert.Equal(t, "franco@francovalencia.com", state.Status()[1].Model().(*user.User).Email)
+
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, state.Applied(), 2)
+
+	res := &user.User{}
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@francovalencia.com", res.Email)
+
+	// Should move an update followed by a delete to the position of the delete and
+	// keep the annotations of both
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "update")
+	state.Stage(user.New("5", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "delete")
+	assert.Len(t, state.Status(), 2)
+	assert.Equal(t, "insert", state.Status()[0].Operation())
+	assert.Equal(t, "delete", state.Status()[1].Operation())
+
+	annotations := state.Status()[1].Annotations()
+	assert.Equal(t, stage, annotations["stage"])
+	assert.Equal(t, stage-2, annotations["update"])
+	assert.Equal(t, stage, annotations["delete"])
+	state.Clear()
+
+	// Should not merge changes when coalescing is disabled
+	state.ToggleCoalescing()
+	state.Stage(user.New("4", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("4", "Jack", "jack@wick.com"), "update")
+	assert.Len(t, state.Status(), 2)
+}
+
+// batchCounter is an in-memory database that counts the batch statements applied to it
+type batchCounter struct {
+	*memdb.DB
+	batches *int
+	gets    *int
+}
+
+func (db *batchCounter) WithContext(ctx context.Context) interfaces.Database { return db }
+
+func (db *batchCounter) Begin() (interfaces.TxDatabase, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &batchCounter{DB: tx.(*memdb.DB), batches: db.batches, gets: db.gets}, nil
+}
+
+func (db *batchCounter) Get(m interfaces.Model, ID interface{}) error {
+	*db.gets++
+	return db.DB.Get(m, ID)
+}
+
+func (db *batchCounter) InsertMany(models []interfaces.Model) error {
+	*db.batches++
+	return db.DB.InsertMany(models)
+}
+
+func (db *batchCounter) UpdateMany(models []interfaces.Model) error {
+	*db.batches++
+	return db.DB.UpdateMany(models)
+}
+
+func (db *batchCounter) DeleteMany(models []interfaces.Model) error {
+	*db.batches++
+	return db.DB.DeleteMany(models)
+}
+
+func TestBatchWithMemDB(t *testing.T) {
+	// Test Setup
+	db := &batchCounter{DB: NewTestMemDatabase().(*memdb.DB), batches: new(int), gets: new(int)}
+	state, err := manager.New(db, NewTestCache())
+	assert.Nil(t, err)
+
+	// Should apply each run of changes with the same schema and operation at once
+	for i := 1; i <= 3; i++ {
+		state.Stage(user.New(strconv.Itoa(i), "User", "user@gmail.com"), "insert")
+	}
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *db.batches)
+	assert.Len(t, state.Applied(), 3)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "update")
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "update")
+	state.Stage(user.New("3", "Vanclief", "vanclief@gmail.com"), "delete")
+	state.Stage(user.New("4", "John", "john@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, *db.batches)
+
+	// Should capture the models of a batch with a single query, only the single
+	// delete is read by its ID
+	assert.Equal(t, 1, *db.gets)
+
+	res := &user.User{}
+	err = state.Get(res, "2", manager.SkipCache())
+	assert.Nil(t, err)
+	assert.Equal(t, "Jack", res.Name)
+
+	// Should capture the previous state of the batched changes so they can be reverted
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+	assert.Equal(t, "User", res.Name)
+
+	err = state.Get(res, "3", manager.SkipCache())
+	assert.Nil(t, err)
+
+	// Should mark the change that caused a batch to fail and persist none of them
+	state.Stage(user.New("5", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("6", "John", "john@gmail.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+
+	status := state.Status()
+	assert.Equal(t, "pending", status[0].Status())
+	assert.Nil(t, status[0].Err())
+	assert.Equal(t, "failure", status[1].Status())
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(status[1].Err()))
+	assert.Equal(t, "pending", status[2].Status())
+	assert.Nil(t, status[2].Err())
+
+	err = state.Get(res, "5", manager.SkipCache())
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+// batchCache is a cache that counts the batches applied to it
+type batchCache struct {
+	interfaces.Cache
+	batches int
+}
+
+func (c *batchCache) SetMany(models []interfaces.Model, ttl int) error {
+	c.batches++
+	for _, m := range models {
+		c.Set(m, ttl)
+	}
+	return nil
+}
+
+func (c *batchCache) DeleteMany(models []interfaces.Model) error {
+	c.batches++
+	for _, m := range models {
+		c.Delete(m)
+	}
+	return nil
+}
+
+func TestBatchCacheWithMemDB(t *testing.T) {
+	// Test Setup
+	cache := &batchCache{Cache: NewTestCache()}
+	state, err := manager.New(NewTestMemDatabase(), cache)
+	assert.Nil(t, err)
+
+	// Should update the cache once per run of sets and deletes
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	state.Stage(user.New("3", "Vanclief", "vanclief@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cache.batches)
+
+	state.Stage(user.New("1", "Franco", "franco@francovalencia.com"), "update")
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "delete")
+	state.Stage(user.New("3", "Vanclief", "vanclief@vanclief.com"), "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, cache.batches)
+	assert.Len(t, state.Applied(), 3)
+
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@francovalencia.com", res.Email)
+
+	err = cache.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should restore the cache when the changes are rolled back
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "2")
+	assert.Nil(t, err)
+}
+
+// slowDB is a database that takes a while to read models and counts the reads, while
+// failing or panicking is set its reads fail or panic
+type slowDB struct {
+	interfaces.Database
+	mu        sync.Mutex
+	reads     int
+	delay     time.Duration
+	failing   bool
+	panicking bool
+}
+
+func (db *slowDB) WithContext(ctx context.Context) interfaces.Database {
+	return &slowCtxDB{slowDB: db, ctx: ctx}
+}
+
+func (db *slowDB) Get(m interfaces.Model, ID interface{}) error {
+	return db.get(context.Background(), m, ID)
+}
+
+func (db *slowDB) get(ctx context.Context, m interfaces.Model, ID interface{}) error {
+	db.mu.Lock()
+	db.reads++
+	failing, panicking := db.failing, db.panicking
+	db.mu.Unlock()
+
+	select {
+	case <-time.After(db.delay):
+	case <-ctx.Done():
+		return ez.New("slowDB.Get", ez.EINTERNAL, "The context of the operation is done", ctx.Err())
+	}
+
+	if panicking {
+		panic("slowDB.Get")
+	}
+	if failing {
+		return ez.New("slowDB.Get", ez.EUNAVAILABLE, "The database is unavailable", nil)
+	}
+
+	return db.Database.Get(m, ID)
+}
+
+func (db *slowDB) Reads() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.reads
+}
+
+func (db *slowDB) Set(failing, panicking bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.failing, db.panicking = failing, panicking
+}
+
+// slowCtxDB is a slowDB whose reads stop when the context is done
+type slowCtxDB struct {
+	*slowDB
+	ctx context.Context
+}
+
+func (db *slowCtxDB) Get(m interfaces.Model, ID interface{}) error {
+	return db.get(db.ctx, m, ID)
+}
+
+func TestSingleflightWithMemDB(t *testing.T) {
+	// Test Setup
+	db := &slowDB{Database: NewTestMemDatabase(), delay: 50 * time.Millisecond}
+	db.Insert(user.New("1", "Franco", "franco@gmail.com"))
+
+	state, err := manager.New(db, NewTestCache())
+	assert.Nil(t, err)
+
+	// Should share a single database read between concurrent cache misses
+	const readers = 10
+
+	results := make([]*user.User, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = &user.User{}
+			err := state.Get(results[i], "1")
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, db.Reads() < readers)
+
+	// Should give every reader its own copy of the model
+	results[0].Name = "Not Franco"
+	for _, res := range results[1:] {
+		assert.Equal(t, "Franco", res.Name)
+	}
+
+	// Should share the error of the database read
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := state.Get(&user.User{}, "404")
+			assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+		}()
+	}
+	wg.Wait()
+
+	// Should stop waiting for the read of another reader when the context is done
+	db.Insert(user.New("4", "Jill", "jill@gmail.com"))
+
+	leader := make(chan error)
+	go func() {
+		leader <- state.Get(&user.User{}, "4")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	start := time.Now()
+	err = state.GetContext(waitCtx, &user.User{}, "4")
+	waitCancel()
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 30*time.Millisecond)
+	assert.Nil(t, <-leader)
+
+	// Should not fail the waiters when the context of the first reader is done
+	db.Insert(user.New("2", "Jack", "jack@gmail.com"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- state.GetContext(ctx, &user.User{}, "2")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	waiter := make(chan error)
+	res := &user.User{}
+	go func() {
+		waiter <- state.Get(res, "2")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	assert.NotNil(t, <-canceled)
+	assert.Nil(t, <-waiter)
+	assert.Equal(t, "Jack", res.Name)
+
+	// Should not leave the waiters blocked when the read panics
+	db.Insert(user.New("3", "Vanclief", "vanclief@vanclief.com"))
+	db.Set(false, true)
+
+	panics := make(chan interface{}, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				panics <- recover()
+			}()
+			state.Get(&user.User{}, "3")
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		assert.NotNil(t, <-panics)
+	}
+
+	db.Set(false, false)
+	err = state.Get(&user.User{}, "3")
+	assert.Nil(t, err)
+}
+
+// laggingDB is a database whose next read returns the model as it was when the read
+// started, after a delay
+type laggingDB struct {
+	interfaces.Database
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (db *laggingDB) Lag(delay time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.delay = delay
+}
+
+func (db *laggingDB) Get(m interfaces.Model, ID interface{}) error {
+	db.mu.Lock()
+	delay := db.delay
+	db.delay = 0
+	db.mu.Unlock()
+
+	err := db.Database.Get(m, ID)
+	time.Sleep(delay)
+	return err
+}
+
+func TestReadThroughRaceWithMemDB(t *testing.T) {
+	// Test Setup
+	db := &laggingDB{Database: NewTestMemDatabase()}
+	db.Insert(user.New("1", "Franco", "franco@gmail.com"))
+
+	cache := NewTestCache()
+	state, err := manager.New(db, cache)
+	assert.Nil(t, err)
+
+	// Should not overwrite the copy cached by a commit with an older read
+	db.Lag(50 * time.Millisecond)
+
+	read := make(chan error)
+	go func() {
+		read <- state.Get(&user.User{}, "1")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	state.Stage(user.New("1", "Not Franco", "franco@gmail.com"), "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Nil(t, <-read)
+
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+
+	// Should keep adding the models read while there are no writes
+	err = cache.Delete(res)
+	assert.Nil(t, err)
+
+	err = state.Get(&user.User{}, "1")
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+}
+
+func TestEarlyRefreshWithMemDB(t *testing.T) {
+	// Test Setup
+	db := &slowDB{Database: NewTestMemDatabase(), delay: time.Millisecond}
+	db.Insert(user.New("1", "Franco", "franco@gmail.com"))
+
+	cache := NewTestCache()
+	cache.SetTTL(60000)
+
+	state, err := manager.New(db, cache)
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.Reads())
+
+	// Should serve the cached model while early refresh is disabled
+	db.Update(user.New("1", "Franco", "franco@francovalencia.com"))
+
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@gmail.com", res.Email)
+	assert.Equal(t, 1, db.Reads())
+
+	// Should refresh the cached model before it expires, a large beta makes the refresh
+	// certain
+	err = state.SetEarlyRefresh(1e9)
+	assert.Nil(t, err)
+
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@francovalencia.com", res.Email)
+	assert.Equal(t, 2, db.Reads())
+
+	// Should serve the cached model if the refresh fails
+	db.Set(true, false)
+
+	res = &user.User{}
+	err = state.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "franco@francovalencia.com", res.Email)
+
+	// Should remove the cached model if it no longer exists
+	db.Set(false, false)
+	db.Delete(user.New("1", "", ""))
+
+	err = state.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	err = cache.Get(&user.User{}, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not allow a negative beta
+	err = state.SetEarlyRefresh(-1)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+// unreliableCache is a cache whose writes fail while failing is set
+type unreliableCache struct {
+	interfaces.Cache
+	failing bool
+}
+
+func (c *unreliableCache) Set(m interfaces.Model, ttl int) error {
+	if c.failing {
+		return ez.New("unreliableCache.Set", ez.EUNAVAILABLE, "The cache is not available", nil)
+	}
+	return c.Cache.Set(m, ttl)
+}
+
+func TestCacheFailureWithMemDB(t *testing.T) {
+	// Test Setup
+	cache := &unreliableCache{Cache: NewTestCache()}
+	state, err := manager.New(NewTestMemDatabase(), cache)
+	assert.Nil(t, err)
+
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	cache.Cache.Set(user1, 0)
+
+	// Should keep the change applied if the cache fails after the commit
+	cache.failing = true
+	state.Stage(user.New("1", "Franco", "franco@francovalencia.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+	assert.Len(t, state.Status(), 0)
+	assert.Len(t, state.Applied(), 1)
+
+	change := state.Applied()[0]
+	assert.Equal(t, "success", change.Status())
+	assert.Nil(t, change.Err())
+	assert.NotNil(t, change.CacheErr())
+
+	// Should evict the model so the cache does not serve a stale copy
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not apply the change again on the next commit
+	cache.failing = false
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, state.Applied(), 1)
+
+	// Should be able to rollback the changes commited while the cache was failing
+	cache.failing = true
+	state.Stage(user.New("3", "Vanclief", "vanclief@gmail.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = state.Get(res, "3", manager.SkipCache())
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+// plainDB is a database that does not support transactions
+type plainDB struct {
+	interfaces.Database
+}
+
+func TestRevertCacheFailureWithMemDB(t *testing.T) {
+	// Test Setup
+	cache := &unreliableCache{Cache: NewTestCache()}
+	state, err := manager.New(&plainDB{Database: NewTestMemDatabase()}, cache)
+	assert.Nil(t, err)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	// Should keep the change reverted if the cache fails after the database is reverted
+	cache.failing = true
+	err = state.Rollback()
+	assert.NotNil(t, err)
+	assert.Len(t, state.Applied(), 0)
+
+	res := &user.User{}
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+
+	// Should not revert the change again on the next rollback
+	cache.failing = false
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	err = state.Get(res, "1", manager.SkipCache())
+	assert.Nil(t, err)
+}
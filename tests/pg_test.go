@@ -12,6 +12,7 @@ import (
 	"github.com/vanclief/state/examplemodels/user"
 	"github.com/vanclief/state/interfaces"
 	"github.com/vanclief/state/manager"
+	"github.com/vanclief/state/query"
 )
 
 func NewTestDatabase() interfaces.Database {
@@ -149,10 +150,16 @@ func TestRollback(t *testing.T) {
 	err := state.Commit()
 	assert.NotNil(t, err)
 
-	assert.Len(t, state.Applied(), 1)
+	// Should not apply any change if one of them fails
+	assert.Len(t, state.Applied(), 0)
 	assert.Len(t, state.Status(), 2)
 
-	// Should be able to rollback changes that where applied
+	res := &user.User{}
+	err = state.DB.Get(res, user1.ID)
+	assert.NotNil(t, err)
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should be able to rollback when there are no applied changes
 	err = state.Rollback()
 	assert.Len(t, state.Applied(), 0)
 	assert.Len(t, state.Status(), 2)
@@ -175,6 +182,34 @@ func TestRollback(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, state.Status(), 0)
 	assert.Len(t, state.Applied(), 0)
+
+	// Should be able to rollback update and delete changes
+	user4 := user.New("4", "Ana", "ana@gmail.com")
+	user5 := user.New("5", "Luis", "luis@gmail.com")
+	state.Stage(user4, "insert")
+	state.Stage(user5, "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	user4.Name = "Not Ana"
+	state.Stage(user4, "update")
+	state.Stage(user5, "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+	assert.Len(t, state.Applied(), 0)
+
+	res = &user.User{}
+	err = state.DB.Get(res, user4.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ana", res.Name)
+
+	res = &user.User{}
+	err = state.DB.Get(res, user5.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Luis", res.Name)
 }
 
 func TestGet(t *testing.T) {
@@ -211,7 +246,7 @@ func TestQueryOne(t *testing.T) {
 
 	// Should be able to get a model that exists
 	res := &user.User{}
-	err := state.QueryOne(res, `email = 'franco@gmail.com'`)
+	err := state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
 	assert.Nil(t, err)
 
 	assert.Equal(t, user1.ID, res.ID)
@@ -220,7 +255,7 @@ func TestQueryOne(t *testing.T) {
 
 	// Should fail if there is no model that matches the query
 	res = &user.User{}
-	err = state.QueryOne(res, `email = 'arcano@gmail.com'`)
+	err = state.QueryOne(res, query.Where(query.Eq("email", "arcano@gmail.com")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
 
@@ -230,7 +265,7 @@ func TestQueryOne(t *testing.T) {
 	state.Commit()
 
 	res = &user.User{}
-	err = state.QueryOne(res, `email = 'franco@gmail.com'`)
+	err = state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
 }
@@ -248,7 +283,7 @@ func TestQuery(t *testing.T) {
 
 	// Should be able to get a model that exists
 	res := []user.User{}
-	err := state.Query(&res, &user.User{}, `name = 'Franco'`)
+	err := state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")))
 	assert.Nil(t, err)
 
 	assert.Len(t, res, 2)
@@ -262,19 +297,19 @@ func TestQuery(t *testing.T) {
 
 	// Should fail if the query is invalid
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `default default default`)
+	err = state.Query(&res, &user.User{}, query.Where(query.Raw(`default default default`)))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.EINTERNAL, ez.ErrorCode(err))
 
 	// Should fail if there is no model that matches the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Francisco'`)
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Francisco")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
 
 	// Should be able to use limit in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco'`, "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user1.ID, res[0].ID)
@@ -283,7 +318,7 @@ func TestQuery(t *testing.T) {
 
 	// Should be able to use limit and offset in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco'`, "1", "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1).Offset(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user2.ID, res[0].ID)
@@ -292,7 +327,7 @@ func TestQuery(t *testing.T) {
 
 	// Should be able to use limit with order by in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco' ORDER BY email DESC`, "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).OrderByDesc("email").Limit(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user2.ID, res[0].ID)
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/query"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	// Should match every model when there are no conditions
+	q := query.New()
+	where, args, err := q.WhereSQL()
+	assert.Nil(t, err)
+	assert.Equal(t, "", where)
+	assert.Len(t, args, 0)
+
+	// Should translate comparisons into SQL with placeholders
+	q = query.Where(query.Eq("name", "Franco"), query.Gt("age", 18))
+	where, args, err = q.WhereSQL()
+	assert.Nil(t, err)
+	assert.Equal(t, `("name" = ? AND "age" > ?)`, where)
+	assert.Equal(t, []interface{}{"Franco", 18}, args)
+
+	// Should translate nested groups and memberships
+	q = query.Where(query.Or(query.In("id", "1", "2"), query.And(query.Like("email", "%@gmail.com"), query.Eq("deleted_at", nil))))
+	where, args, err = q.WhereSQL()
+	assert.Nil(t, err)
+	assert.Equal(t, `("id" IN (?, ?) OR ("email" LIKE ? AND "deleted_at" IS NULL))`, where)
+	assert.Equal(t, []interface{}{"1", "2", "%@gmail.com"}, args)
+
+	// Should never match an empty membership
+	where, _, err = query.Where(query.In("id")).WhereSQL()
+	assert.Nil(t, err)
+	assert.Equal(t, "1 = 0", where)
+
+	// Should keep raw conditions with their args
+	q = query.Where(query.Raw("lower(name) = ?", "franco")).Where(query.Neq("id", "1"))
+	where, args, err = q.WhereSQL()
+	assert.Nil(t, err)
+	assert.Equal(t, `((lower(name) = ?) AND "id" != ?)`, where)
+	assert.Equal(t, []interface{}{"franco", "1"}, args)
+
+	// Should not translate unknown operators
+	q = query.Where(query.Comparison{Field: "id", Operator: "= 1 OR 1 =", Value: "1"})
+	_, _, err = q.WhereSQL()
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	q = query.Where(query.Group{Operator: "OR 1 = 1 OR", Conditions: []query.Condition{query.Eq("id", "1"), query.Eq("id", "2")}})
+	_, _, err = q.WhereSQL()
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should translate the order
+	q = query.New().OrderByDesc("email").OrderBy("name").Limit(10).Offset(5)
+	assert.Equal(t, `"email" DESC, "name"`, q.OrderSQL())
+	assert.Equal(t, 10, q.GetLimit())
+	assert.Equal(t, 5, q.GetOffset())
+
+	// Should represent equal queries in the same way
+	q1 := query.Where(query.Eq("age", 1)).Limit(1)
+	q2 := query.Where(query.Eq("age", 1)).Limit(1)
+	q3 := query.Where(query.Eq("age", "1")).Limit(1)
+	assert.Equal(t, q1.String(), q2.String())
+	assert.NotEqual(t, q1.String(), q3.String())
+
+	// Should represent pointer args by their values instead of their addresses
+	age1, age2 := 1, 1
+	assert.Equal(t, query.Where(query.Eq("age", &age1)).String(), query.Where(query.Eq("age", &age2)).String())
+	assert.Equal(t, q1.String(), query.Where(query.Eq("age", &age1)).Limit(1).String())
+
+	age1 = 2
+	assert.NotEqual(t, query.Where(query.Eq("age", &age1)).String(), query.Where(query.Eq("age", &age2)).String())
+}
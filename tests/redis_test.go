@@ -2,7 +2,9 @@ package tests
 
 import (
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/vanclief/ez"
 
 	"github.com/stretchr/testify/assert"
@@ -10,6 +12,7 @@ import (
 	"github.com/vanclief/state/examplemodels/user"
 	"github.com/vanclief/state/interfaces"
 	"github.com/vanclief/state/manager"
+	"github.com/vanclief/state/query"
 )
 
 func NewTestRedisCache() interfaces.Cache {
@@ -71,7 +74,7 @@ func TestQueryOneWithRedis(t *testing.T) {
 
 	// Should be able to get a model that exists
 	res := &user.User{}
-	err := state.QueryOne(res, `email = 'franco@gmail.com'`)
+	err := state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
 	assert.Nil(t, err)
 
 	assert.Equal(t, user1.ID, res.ID)
@@ -80,7 +83,7 @@ func TestQueryOneWithRedis(t *testing.T) {
 
 	// Should fail if there is no model that matches the query
 	res = &user.User{}
-	err = state.QueryOne(res, `email = 'arcano@gmail.com'`)
+	err = state.QueryOne(res, query.Where(query.Eq("email", "arcano@gmail.com")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
 
@@ -90,7 +93,7 @@ func TestQueryOneWithRedis(t *testing.T) {
 	state.Commit()
 
 	res = &user.User{}
-	err = state.QueryOne(res, `email = 'franco@gmail.com'`)
+	err = state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
 }
@@ -108,7 +111,7 @@ func TestQueryWithRedis(t *testing.T) {
 
 	// Should be able to get a model that exists
 	res := []user.User{}
-	err := state.Query(&res, &user.User{}, `name = 'Franco'`)
+	err := state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")))
 	assert.Nil(t, err)
 
 	assert.Len(t, res, 2)
@@ -122,13 +125,13 @@ func TestQueryWithRedis(t *testing.T) {
 
 	// Should fail if there is no model that matches the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Francisco'`)
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Francisco")))
 	assert.NotNil(t, err)
 	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
 
 	// Should be able to use limit in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco'`, "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user1.ID, res[0].ID)
@@ -137,7 +140,7 @@ func TestQueryWithRedis(t *testing.T) {
 
 	// Should be able to use limit and offset in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco'`, "1", "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1).Offset(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user2.ID, res[0].ID)
@@ -146,7 +149,7 @@ func TestQueryWithRedis(t *testing.T) {
 
 	// Should be able to use limit with order by in the query
 	res = []user.User{}
-	err = state.Query(&res, &user.User{}, `name = 'Franco' ORDER BY email DESC`, "1")
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).OrderByDesc("email").Limit(1))
 	assert.Nil(t, err)
 	assert.Len(t, res, 1)
 	assert.Equal(t, user2.ID, res[0].ID)
@@ -154,3 +157,99 @@ func TestQueryWithRedis(t *testing.T) {
 	assert.Equal(t, user2.Email, res[0].Email)
 
 }
+
+func TestBatchWithRedis(t *testing.T) {
+	// Test Setup
+	cache := NewTestRedisCache().(interfaces.BatchCache)
+	users := []interfaces.Model{
+		user.New("1", "Franco", "franco@gmail.com"),
+		user.New("2", "Jack", "jack@gmail.com"),
+	}
+
+	// Should be able to set many models at once
+	err := cache.SetMany(users, 1000)
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = cache.Get(res, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jack", res.Name)
+
+	// Should be able to delete many models at once
+	err = cache.DeleteMany(users)
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestPurgeWithRedis(t *testing.T) {
+	// Test Setup
+	cache := NewTestRedisCache().(*redis.RedisStorage)
+	other := NewTestRedisCache().(*redis.RedisStorage)
+	other.SetNamespace("other")
+
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	cache.Set(user1, 10000)
+	other.Set(user1, 10000)
+
+	// Should only purge the keys of a schema
+	err := cache.PurgeSchema("books")
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+
+	err = cache.PurgeSchema(user1.GetSchema().Name)
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should only purge the keys under the namespace
+	cache.Set(user1, 10000)
+	err = cache.Purge()
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	err = other.Get(res, "1")
+	assert.Nil(t, err)
+
+	// Should not allow an empty namespace
+	err = cache.SetNamespace("")
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	other.Purge()
+}
+
+func TestExpiryWithMiniredis(t *testing.T) {
+	// Test Setup
+	server, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer server.Close()
+
+	cache, err := redis.New(server.Addr(), "", 0)
+	assert.Nil(t, err)
+
+	err = cache.Set(user.New("1", "Franco", "franco@gmail.com"), 60000)
+	assert.Nil(t, err)
+
+	// Should report the expiry of a model with the same IDs as Get
+	expires, err := cache.Expiry(&user.User{}, "1")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, time.Second)
+
+	expires, err = cache.Expiry(&user.User{}, []byte("1"))
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, time.Second)
+
+	_, err = cache.Expiry(&user.User{}, 1)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should not find a model that is not stored
+	_, err = cache.Expiry(&user.User{}, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/caches/shardedcache"
+	"github.com/vanclief/state/caches/simplecache"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/manager"
+)
+
+func TestGetWithShardedCache(t *testing.T) {
+	// Test Setup
+	cache := shardedcache.New(shardedcache.Shards(4))
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+
+	// Should store a copy of the model
+	err := cache.Set(user1, 0)
+	assert.Nil(t, err)
+	user1.Name = "Not Franco"
+
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	// Should report the expiry of a model with the same IDs as Get
+	expires, err := cache.Expiry(&user.User{}, []byte("1"))
+	assert.Nil(t, err)
+	assert.True(t, expires.IsZero())
+
+	_, err = cache.Expiry(&user.User{}, 1)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should not be able to get a deleted model
+	err = cache.Delete(user1)
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not serve a model after its TTL expired
+	cache.Set(user1, 10)
+	time.Sleep(20 * time.Millisecond)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+	assert.Equal(t, 0, cache.Len())
+
+	// Should clear every shard
+	for i := 0; i < 20; i++ {
+		cache.Set(user.New(strconv.Itoa(i), "Franco", "franco@gmail.com"), 0)
+	}
+	assert.Equal(t, 20, cache.Len())
+
+	err = cache.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestJanitorWithShardedCache(t *testing.T) {
+	// Test Setup
+	cache := shardedcache.New(shardedcache.CleanupInterval(5 * time.Millisecond))
+	defer cache.Close()
+
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 10)
+	cache.Set(user.New("2", "Jack", "jack@gmail.com"), 0)
+
+	// Should remove the expired models in the background
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestConcurrencyWithShardedCache(t *testing.T) {
+	// Test Setup
+	cache := shardedcache.New(shardedcache.Shards(8), shardedcache.CleanupInterval(time.Millisecond))
+	defer cache.Close()
+
+	// Should be safe to read, write, delete and purge from multiple goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				id := strconv.Itoa((i * j) % 100)
+				cache.Set(user.New(id, "Franco", "franco@gmail.com"), j%3)
+				cache.Get(&user.User{}, id)
+				if j%10 == 0 {
+					cache.Delete(user.New(id, "", ""))
+				}
+				if j%100 == 0 {
+					cache.Purge()
+					cache.SetTTL(j)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, cache.Len() <= 100)
+}
+
+func TestManagerWithShardedCache(t *testing.T) {
+	// Test Setup
+	state, err := manager.New(NewTestMemDatabase(), shardedcache.New())
+	assert.Nil(t, err)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	// Should serve models from concurrent requests
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &user.User{}
+			err := state.Get(res, "1")
+			assert.Nil(t, err)
+			assert.Equal(t, "Franco", res.Name)
+		}()
+	}
+	wg.Wait()
+}
+
+// benchmarkParallel reads and writes a set of models from multiple goroutines, one in
+// every ten operations is a write
+func benchmarkParallel(b *testing.B, cache interfaces.Cache) {
+	const models = 1000
+
+	for i := 0; i < models; i++ {
+		cache.Set(user.New(strconv.Itoa(i), "Franco", "franco@gmail.com"), 0)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		res := &user.User{}
+		i := 0
+		for pb.Next() {
+			id := strconv.Itoa(i % models)
+			if i%10 == 0 {
+				cache.Set(user.New(id, "Franco", "franco@gmail.com"), 0)
+			} else {
+				cache.Get(res, id)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelWithSimpleCache(b *testing.B) {
+	benchmarkParallel(b, simplecache.New())
+}
+
+func BenchmarkParallelWithShardedCache(b *testing.B) {
+	benchmarkParallel(b, shardedcache.New())
+}
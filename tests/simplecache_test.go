@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/caches/simplecache"
+	"github.com/vanclief/state/examplemodels/book"
+	"github.com/vanclief/state/examplemodels/user"
+)
+
+func TestTTLWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+
+	// Should store a copy of the model
+	err := cache.Set(user1, 0)
+	assert.Nil(t, err)
+	user1.Name = "Not Franco"
+
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	// Should report the expiry of a model with the same IDs as Get
+	expires, err := cache.Expiry(&user.User{}, []byte("1"))
+	assert.Nil(t, err)
+	assert.True(t, expires.IsZero())
+
+	_, err = cache.Expiry(&user.User{}, 1)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+
+	// Should not serve a model after its TTL expired
+	err = cache.Set(user1, 10)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+	assert.Equal(t, 0, cache.Len())
+
+	// Should not use the same key for models of different schemas
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 0)
+	cache.Set(book.New("1", "Dune", "Frank Herbert"), 0)
+	assert.Equal(t, 2, cache.Len())
+
+	// Should not allow a negative TTL
+	err = cache.SetTTL(-1)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestJanitorWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.CleanupInterval(5 * time.Millisecond))
+	defer cache.Close()
+
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 10)
+	cache.Set(user.New("2", "Jack", "jack@gmail.com"), 0)
+
+	// Should remove the expired models in the background
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestLRUWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.MaxEntries(2))
+	res := &user.User{}
+
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 0)
+	cache.Set(user.New("2", "Jack", "jack@gmail.com"), 0)
+	cache.Get(res, "1")
+
+	// Should evict the least recently used model
+	cache.Set(user.New("3", "Vanclief", "vanclief@gmail.com"), 0)
+	assert.Equal(t, 2, cache.Len())
+
+	err := cache.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+
+	// Should not evict when replacing a stored model
+	cache.Set(user.New("3", "Vanclief", "vanclief@vanclief.com"), 0)
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+}
+
+func TestLFUWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.MaxEntries(2), simplecache.Eviction(simplecache.LFU))
+	res := &user.User{}
+
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 0)
+	cache.Set(user.New("2", "Jack", "jack@gmail.com"), 0)
+	cache.Get(res, "1")
+	cache.Get(res, "1")
+	cache.Get(res, "2")
+
+	// Should evict the least frequently used model
+	cache.Set(user.New("3", "Vanclief", "vanclief@gmail.com"), 0)
+
+	err := cache.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not evict the new model just because it has been used the least
+	err = cache.Get(res, "3")
+	assert.Nil(t, err)
+
+	cache.Set(user.New("4", "John", "john@gmail.com"), 0)
+
+	err = cache.Get(res, "3")
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "4")
+	assert.Nil(t, err)
+
+	// Should eventually evict a model that was only used long ago
+	cache = simplecache.New(simplecache.MaxEntries(2), simplecache.Eviction(simplecache.LFU))
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 0)
+	for i := 0; i < 4; i++ {
+		cache.Get(res, "1")
+	}
+
+	for i := 2; i <= 6; i++ {
+		cache.Set(user.New(strconv.Itoa(i), "Jack", "jack@gmail.com"), 0)
+		cache.Get(res, strconv.Itoa(i))
+	}
+
+	err = cache.Get(res, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	err = cache.Get(res, "6")
+	assert.Nil(t, err)
+}
+
+func TestEvictExpiredWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.MaxEntries(2))
+	res := &user.User{}
+
+	cache.Set(user.New("1", "Franco", "franco@gmail.com"), 0)
+	cache.Set(user.New("2", "Jack", "jack@gmail.com"), 10)
+	time.Sleep(20 * time.Millisecond)
+
+	// Should remove the expired models before evicting a live one
+	cache.Set(user.New("3", "Vanclief", "vanclief@gmail.com"), 0)
+	assert.Equal(t, 2, cache.Len())
+
+	err := cache.Get(res, "1")
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "3")
+	assert.Nil(t, err)
+}
+
+func TestMaxBytesWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.MaxBytes(150))
+
+	// Should evict models to stay within the byte budget
+	for i := 0; i < 10; i++ {
+		err := cache.Set(user.New(strconv.Itoa(i), "Franco", "franco@gmail.com"), 0)
+		assert.Nil(t, err)
+	}
+	assert.True(t, cache.Len() < 10)
+	assert.True(t, cache.Len() > 0)
+
+	res := &user.User{}
+	err := cache.Get(res, "9")
+	assert.Nil(t, err)
+
+	// Should not store a model larger than the byte budget
+	large := user.New("large", string(make([]byte, 200)), "large@gmail.com")
+	err = cache.Set(large, 0)
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "large")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestConcurrencyWithSimpleCache(t *testing.T) {
+	// Test Setup
+	cache := simplecache.New(simplecache.MaxEntries(50), simplecache.Eviction(simplecache.LFU))
+
+	// Should be safe to use from multiple goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				id := strconv.Itoa((i * j) % 100)
+				cache.Set(user.New(id, "Franco", "franco@gmail.com"), 0)
+				cache.Get(&user.User{}, id)
+				if j%10 == 0 {
+					cache.Delete(user.New(id, "", ""))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, cache.Len() <= 50)
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/databases/sqlitedb"
+	"github.com/vanclief/state/examplemodels/book"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/manager"
+	"github.com/vanclief/state/query"
+)
+
+func NewTestSQLiteDatabase() interfaces.Database {
+	// Create a new database that only lives in memory
+	db, err := sqlitedb.New(":memory:")
+	if err != nil {
+		panic(err)
+	}
+
+	dbModels := []interface{}{(&user.User{})}
+
+	// Create the database schema
+	err = db.CreateSchema(dbModels, true)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+func NewMockManagerWithSQLite() *manager.Manager {
+	db := NewTestSQLiteDatabase()
+	cache := NewTestCache()
+
+	state, err := manager.New(db, cache)
+	if err != nil {
+		panic(err)
+	}
+
+	return state
+}
+
+func TestCommitWithSQLite(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithSQLite()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+
+	// Should be able to apply insert
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+	assert.Len(t, state.Status(), 0)
+
+	// Should be able to apply update
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = state.DB.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Not Franco", res.Name)
+	assert.Equal(t, "franco@gmail.com", res.Email)
+
+	// Should be able to apply delete
+	state.Stage(user2, "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.DB.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not apply any change if one of them fails
+	user3 := user.New("3", "Jacob", "jacob@gmail.com")
+	book := book.New("1", "El master fuster", "Franco") // Book is not in the database schema
+	state.Stage(user3, "insert")
+	state.Stage(book, "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+	assert.Len(t, state.Applied(), 0)
+
+	err = state.DB.Get(res, "3")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not be able to insert a model that already exists
+	state.Clear()
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.NotNil(t, err)
+}
+
+func TestRollbackWithSQLite(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithSQLite()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	err := state.Commit()
+	assert.Nil(t, err)
+
+	// Should be able to rollback update and delete changes
+	user1.Name = "Not Franco"
+	state.Stage(user1, "update")
+	state.Stage(user2, "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = state.Rollback()
+	assert.Nil(t, err)
+
+	res := &user.User{}
+	err = state.DB.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+
+	res = &user.User{}
+	err = state.DB.Get(res, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Jack", res.Name)
+}
+
+func TestQueryOneWithSQLite(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithSQLite()
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	state.Stage(user1, "insert")
+	state.Commit()
+
+	// Should be able to get a model that exists
+	res := &user.User{}
+	err := state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
+	assert.Nil(t, err)
+	assert.Equal(t, user1.ID, res.ID)
+	assert.Equal(t, user1.Name, res.Name)
+	assert.Equal(t, user1.Email, res.Email)
+
+	// Should fail if there is no model that matches the query
+	res = &user.User{}
+	err = state.QueryOne(res, query.Where(query.Eq("email", "arcano@gmail.com")))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should not be possible to inject SQL through the query args
+	res = &user.User{}
+	err = state.QueryOne(res, query.Where(query.Eq("email", "' OR 1=1 --")))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should fail if there is more than one model that matches the query
+	user2 := user.New("2", "Franco's Impostor", "franco@gmail.com")
+	state.Stage(user2, "insert")
+	state.Commit()
+
+	res = &user.User{}
+	err = state.QueryOne(res, query.Where(query.Eq("email", "franco@gmail.com")))
+	assert.Equal(t, ez.ECONFLICT, ez.ErrorCode(err))
+}
+
+func TestQueryWithSQLite(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithSQLite()
+	user1 := user.New("1", "Franco", "email@francovalencia.com")
+	user2 := user.New("2", "Franco", "franco@gmail.com")
+	user3 := user.New("3", "Vanclief", "vanclief@vanclief.com")
+	state.Stage(user1, "insert")
+	state.Stage(user2, "insert")
+	state.Stage(user3, "insert")
+	state.Commit()
+
+	// Should be able to get the models that satisfy the query
+	res := []user.User{}
+	err := state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")))
+	assert.Nil(t, err)
+	assert.Len(t, res, 2)
+	assert.Equal(t, user1.ID, res[0].ID)
+	assert.Equal(t, user2.ID, res[1].ID)
+
+	// Should fail if the query is invalid
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Raw(`default default default`)))
+	assert.Equal(t, ez.EINTERNAL, ez.ErrorCode(err))
+
+	// Should be able to use memberships and raw conditions
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.In("id", "1", "3"), query.Raw("email != ?", "vanclief@vanclief.com")))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user1.ID, res[0].ID)
+
+	// Should fail if there is no model that matches the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Francisco")))
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should be able to use limit and offset in the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).Limit(1).Offset(1))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user2.ID, res[0].ID)
+
+	// Should be able to use limit with order by in the query
+	res = []user.User{}
+	err = state.Query(&res, &user.User{}, query.Where(query.Eq("name", "Franco")).OrderByDesc("email").Limit(1))
+	assert.Nil(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, user2.ID, res[0].ID)
+}
+
+func TestContextWithSQLite(t *testing.T) {
+	// Test Setup
+	state := NewMockManagerWithSQLite()
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err := state.CommitContext(context.Background())
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Should not query with a canceled context
+	res := []user.User{}
+	err = state.QueryContext(ctx, &res, &user.User{}, query.New())
+	assert.NotNil(t, err)
+
+	err = state.RawQueryContext(ctx, &res, &user.User{}, `SELECT * FROM users`)
+	assert.NotNil(t, err)
+
+	// Should not commit with a canceled context
+	state.Stage(user.New("2", "Jack", "jack@gmail.com"), "insert")
+	err = state.CommitContext(ctx)
+	assert.NotNil(t, err)
+
+	one := &user.User{}
+	err = state.Get(one, "2", manager.SkipCache())
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should be able to commit once the context is valid
+	err = state.CommitContext(context.Background())
+	assert.Nil(t, err)
+
+	one = &user.User{}
+	err = state.Get(one, "2", manager.SkipCache())
+	assert.Nil(t, err)
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vanclief/ez"
+	"github.com/vanclief/state/caches/simplecache"
+	"github.com/vanclief/state/caches/tiered"
+	"github.com/vanclief/state/examplemodels/user"
+	"github.com/vanclief/state/interfaces"
+	"github.com/vanclief/state/manager"
+)
+
+// countingCache is a cache that counts its reads
+type countingCache struct {
+	interfaces.Cache
+	gets int
+}
+
+func (c *countingCache) Get(m interfaces.Model, ID interface{}) error {
+	c.gets++
+	return c.Cache.Get(m, ID)
+}
+
+func TestGetWithTieredCache(t *testing.T) {
+	// Test Setup
+	l1 := simplecache.New()
+	l2 := &countingCache{Cache: simplecache.New()}
+	cache, err := tiered.New(l1, l2)
+	assert.Nil(t, err)
+
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	l2.Set(user1, 0)
+
+	// Should read a model from L2 and promote it to L1
+	res := &user.User{}
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Franco", res.Name)
+	assert.Equal(t, 1, l1.Len())
+
+	err = cache.Get(res, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, l2.gets)
+
+	// Should write to both levels
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+	err = cache.Set(user2, 0)
+	assert.Nil(t, err)
+
+	err = l1.Get(res, "2")
+	assert.Nil(t, err)
+	err = l2.Get(res, "2")
+	assert.Nil(t, err)
+
+	// Should delete from both levels
+	err = cache.Delete(user2)
+	assert.Nil(t, err)
+
+	err = cache.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+	err = l2.Get(res, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should require both levels
+	_, err = tiered.New(l1, nil)
+	assert.Equal(t, ez.EINVALID, ez.ErrorCode(err))
+}
+
+func TestExpiryWithTieredCache(t *testing.T) {
+	// Test Setup
+	l1 := simplecache.New()
+	l2 := simplecache.New()
+	cache, err := tiered.New(l1, l2)
+	assert.Nil(t, err)
+
+	var _ interfaces.ExpiryCache = cache
+
+	// Should report the expiry of L1 when the model is there
+	user1 := user.New("1", "Franco", "franco@gmail.com")
+	err = l2.Set(user1, 60000)
+	assert.Nil(t, err)
+	err = l1.Set(user1, 1000)
+	assert.Nil(t, err)
+
+	expires, err := cache.Expiry(&user.User{}, "1")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Second), expires, 500*time.Millisecond)
+
+	// Should report the expiry of L2 when the model is not in L1
+	err = l1.Delete(user1)
+	assert.Nil(t, err)
+
+	expires, err = cache.Expiry(&user.User{}, "1")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 500*time.Millisecond)
+
+	// Should not find a model that is in neither level
+	_, err = cache.Expiry(&user.User{}, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+
+	// Should promote a model for the time it has left in L2
+	user2 := user.New("2", "Jack", "jack@gmail.com")
+	err = l2.Set(user2, 100)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	err = cache.Get(&user.User{}, "2")
+	assert.Nil(t, err)
+
+	expires, err = l1.Expiry(&user.User{}, "2")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(50*time.Millisecond), expires, 30*time.Millisecond)
+
+	time.Sleep(80 * time.Millisecond)
+	err = l1.Get(&user.User{}, "2")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}
+
+func TestManagerWithTieredCache(t *testing.T) {
+	// Test Setup
+	l2 := &countingCache{Cache: simplecache.New()}
+	cache, err := tiered.New(simplecache.New(), l2)
+	assert.Nil(t, err)
+
+	state, err := manager.New(NewTestMemDatabase(), cache)
+	assert.Nil(t, err)
+
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "insert")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	// Should serve hot models from L1
+	for i := 0; i < 3; i++ {
+		res := &user.User{}
+		err = state.Get(res, "1")
+		assert.Nil(t, err)
+		assert.Equal(t, "Franco", res.Name)
+	}
+	assert.Equal(t, 0, l2.gets)
+
+	// Should remove a deleted model from both levels
+	state.Stage(user.New("1", "Franco", "franco@gmail.com"), "delete")
+	err = state.Commit()
+	assert.Nil(t, err)
+
+	err = cache.Get(&user.User{}, "1")
+	assert.Equal(t, ez.ENOTFOUND, ez.ErrorCode(err))
+}